@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// What templates see as .Capabilities of the cluster they are rendered for
+type ClusterStruct struct {
+	KubeVersion string   `yaml:"kubeVersion,omitempty"`
+	APIVersions []string `yaml:"apiVersions,omitempty"`
+}
+
+// Take the kube version and API versions of the release's cluster profile.
+// --kube-version overrides the profile, --api-versions are added to it
+func applyClusterProfile(release *releaseStruct, config *configStruct) {
+	if release.Cluster != "" {
+		cluster, found := config.Clusters[release.Cluster]
+		if !found {
+			fmt.Printf("ERROR! Cluster \"%v\" of release %v is not in %v!\n", release.Cluster, release.Name, config.FilePath)
+			exit(1)
+		}
+		printDebug("Using cluster profile %v for release %v\n", release.Cluster, release.Name)
+
+		if release.KubeVersion == "" {
+			release.KubeVersion = cluster.KubeVersion
+		}
+		release.APIVersions = append(append([]string{}, cluster.APIVersions...), release.APIVersions...)
+	}
+
+	if release.KubeVersion != "" {
+		if _, err := chartutil.ParseKubeVersion(release.KubeVersion); err != nil {
+			fmt.Printf("ERROR! Invalid kube version \"%v\" of release %v: %v\n", release.KubeVersion, release.Name, err)
+			exit(1)
+		}
+	}
+}
+
+// flag.Value for repeatable, comma-separated --api-versions
+type apiVersionsValue []string
+
+func (a *apiVersionsValue) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *apiVersionsValue) Set(text string) error {
+	for _, apiVersion := range strings.Split(text, ",") {
+		if apiVersion = strings.TrimSpace(apiVersion); apiVersion != "" {
+			*a = append(*a, apiVersion)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Exit codes of the check command, combined if there are several problems
+const exitCodeDrifted = 2
+const exitCodeMissing = 4
+const exitCodeExtra = 8
+
+type CheckResultStruct struct {
+	Release  string   `json:"release,omitempty"`
+	UpToDate int      `json:"upToDate"`
+	Drifted  []string `json:"drifted"`
+	Missing  []string `json:"missing"`
+	Extra    []string `json:"extra"`
+	ExitCode int      `json:"exitCode"`
+}
+
+// Compare the manifests of this run with the committed output directory.
+// Nothing is written
+func checkManifests(manifests []*outputManifestStruct, outputDir string) *CheckResultStruct {
+	result := CheckResultStruct{Drifted: []string{}, Missing: []string{}, Extra: []string{}}
+	planned := map[string]bool{}
+
+	for _, manifest := range manifests {
+		relPath, err := filepath.Rel(outputDir, manifest.Filename)
+		checkErr(err)
+		relPath = filepath.ToSlash(relPath)
+		planned[relPath] = true
+
+		if fileIsAbsent(manifest.Filename) {
+			result.Missing = append(result.Missing, relPath)
+			continue
+		}
+
+		committed, err := os.ReadFile(manifest.Filename)
+		checkErr(err)
+		if !bytes.Equal(normalizeManifest(committed), normalizeManifest(manifest.Content)) {
+			result.Drifted = append(result.Drifted, relPath)
+			printDebug("%v", unifiedDiff(manifest.Filename, manifest.Filename, committed, manifest.Content))
+			continue
+		}
+		result.UpToDate++
+	}
+
+	result.Extra = extraFiles(outputDir, planned)
+
+	sort.Strings(result.Drifted)
+	sort.Strings(result.Missing)
+	if len(result.Drifted) > 0 {
+		result.ExitCode |= exitCodeDrifted
+	}
+	if len(result.Missing) > 0 {
+		result.ExitCode |= exitCodeMissing
+	}
+	if len(result.Extra) > 0 {
+		result.ExitCode |= exitCodeExtra
+	}
+
+	if !jsonOutput {
+		printCheckResult(&result, outputDir)
+	}
+
+	return &result
+}
+
+// With --json all results are printed at the end: an object for a single
+// release, or a list of objects for a project
+func printCheckResults(results []*CheckResultStruct, isProject bool) {
+	if !jsonOutput {
+		return
+	}
+
+	var resultsByte []byte
+	var err error
+	if isProject {
+		resultsByte, err = json.MarshalIndent(results, "", "  ")
+	} else {
+		resultsByte, err = json.MarshalIndent(results[0], "", "  ")
+	}
+	checkErr(err)
+	fmt.Println(string(resultsByte))
+}
+
+func printCheckResult(result *CheckResultStruct, outputDir string) {
+	for _, file := range result.Drifted {
+		fmt.Println("Drifted:", path.Join(outputDir, file))
+	}
+	for _, file := range result.Missing {
+		fmt.Println("Missing:", path.Join(outputDir, file))
+	}
+	for _, file := range result.Extra {
+		fmt.Println("Extra:  ", path.Join(outputDir, file))
+	}
+
+	fmt.Printf("%v up to date, %v drifted, %v missing, %v extra\n", result.UpToDate, len(result.Drifted), len(result.Missing), len(result.Extra))
+	if result.ExitCode != 0 {
+		fmt.Printf("%v is out of date, rerun helm-splitter to update it\n", outputDir)
+	}
+}
+
+// YAML files in the output directory which this run would not generate.
+// The state file of --prune is not a manifest and is never extra
+func extraFiles(outputDir string, planned map[string]bool) []string {
+	extra := []string{}
+	if fileIsAbsent(outputDir) {
+		return extra
+	}
+
+	err := filepath.WalkDir(outputDir, func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		relPath, err := filepath.Rel(outputDir, filename)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		extension := path.Ext(relPath)
+		if relPath == stateFilename || (extension != ".yaml" && extension != ".yml") || planned[relPath] {
+			return nil
+		}
+		extra = append(extra, relPath)
+		return nil
+	})
+	checkErr(err)
+
+	return extra
+}
+
+// Ignore differences which do not change the manifest:
+// line endings, trailing spaces, blank lines at the end
+// and a leading document marker
+func normalizeManifest(content []byte) []byte {
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	text = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	text = strings.TrimPrefix(text, "---\n")
+
+	return []byte(text + "\n")
+}
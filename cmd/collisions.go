@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const collisionNamespace = "namespace"
+const collisionGroup = "group"
+const collisionHash = "hash"
+
+func validateCollisionStrategy(collisionStrategy string) {
+	for _, strategy := range collisionStrategies(collisionStrategy) {
+		if strategy != collisionNamespace && strategy != collisionGroup && strategy != collisionHash {
+			fmt.Printf("ERROR! Unknown collision strategy \"%v\", must be \"%v\", \"%v\" or \"%v\"!\n", strategy, collisionNamespace, collisionGroup, collisionHash)
+			exit(1)
+		}
+	}
+}
+
+func collisionStrategies(collisionStrategy string) []string {
+	var strategies []string
+	for _, strategy := range strings.Split(collisionStrategy, ",") {
+		if strategy = strings.TrimSpace(strategy); strategy != "" {
+			strategies = append(strategies, strategy)
+		}
+	}
+	return strategies
+}
+
+// Set the output filename of every manifest. Manifests which would be
+// written to the same file get a suffix from each strategy in turn, until
+// all filenames are unique. If they are still not, list the conflicts and exit
+func resolveFilenames(manifests []*outputManifestStruct, config *configStruct) {
+	filenameTemplate := parseFilenameTemplate(config.FilenameTemplate)
+
+	for _, manifest := range manifests {
+		manifest.Filename = manifestFilename(filenameTemplate, manifest)
+	}
+
+	for _, strategy := range collisionStrategies(config.CollisionStrategy) {
+		collisions := findCollisions(manifests)
+		if len(collisions) == 0 {
+			return
+		}
+
+		for filename, colliding := range collisions {
+			printDebug("Filename %v is used by %v manifests, adding %v to their names\n", filename, len(colliding), strategy)
+			for _, manifest := range colliding {
+				if suffix := collisionSuffix(manifest, strategy); suffix != "" {
+					manifest.Suffix += "-" + suffix
+					manifest.Filename = manifestFilename(filenameTemplate, manifest)
+				}
+			}
+		}
+	}
+
+	collisions := findCollisions(manifests)
+	if len(collisions) == 0 {
+		return
+	}
+
+	filenames := make([]string, 0, len(collisions))
+	for filename := range collisions {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	fmt.Println("ERROR! Several manifests would be written to the same file:")
+	for _, filename := range filenames {
+		fmt.Printf("%v:\n", filename)
+		for _, manifest := range collisions[filename] {
+			fmt.Printf("  %v %v/%v (namespace: %v) from %v\n", manifest.APIVersion, manifest.Kind, manifest.Name, manifest.Namespace, manifest.Source)
+		}
+	}
+	fmt.Println("Use --collision-strategy (or collisionStrategy in the config) to tell them apart")
+	exit(1)
+}
+
+func findCollisions(manifests []*outputManifestStruct) map[string][]*outputManifestStruct {
+	byFilename := map[string][]*outputManifestStruct{}
+	for _, manifest := range manifests {
+		byFilename[manifest.Filename] = append(byFilename[manifest.Filename], manifest)
+	}
+
+	collisions := map[string][]*outputManifestStruct{}
+	for filename, sameFilename := range byFilename {
+		if len(sameFilename) > 1 {
+			collisions[filename] = sameFilename
+		}
+	}
+	return collisions
+}
+
+func collisionSuffix(manifest *outputManifestStruct, strategy string) string {
+	switch strategy {
+	case collisionNamespace:
+		return manifest.Namespace
+	case collisionGroup:
+		return apiGroup(manifest.APIVersion)
+	case collisionHash:
+		// Hash the identity of the resource rather than its content,
+		// so the filename stays the same between runs
+		identity := strings.Join([]string{manifest.APIVersion, manifest.Kind, manifest.Namespace, manifest.Name}, "/")
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(identity)))[:8]
+	}
+	return ""
+}
+
+// Get the API group from apiVersion, e.g. apps/v1 -> apps, v1 -> core
+func apiGroup(apiVersion string) string {
+	group, _, found := strings.Cut(apiVersion, "/")
+	if !found {
+		return "core"
+	}
+	return group
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveFilenames(t *testing.T) {
+	serviceAccount := func(namespace string) *outputManifestStruct {
+		return &outputManifestStruct{APIVersion: "v1", Kind: "ServiceAccount", Shortcut: "sa", Name: "app", Namespace: namespace, OutputDir: "out"}
+	}
+	// Two cluster-scoped kinds sharing a shortcut
+	clusterScoped := func() []*outputManifestStruct {
+		return []*outputManifestStruct{
+			{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Shortcut: "cr", Name: "view", OutputDir: "out"},
+			{APIVersion: "example.com/v1", Kind: "ClusterRoleTemplate", Shortcut: "cr", Name: "view", OutputDir: "out"},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		strategy  string
+		manifests []*outputManifestStruct
+		want      []string
+	}{
+		{
+			name:      "no collisions",
+			manifests: []*outputManifestStruct{serviceAccount("a")},
+			want:      []string{"out/sa-app.yaml"},
+		},
+		{
+			name:      "same name in two namespaces",
+			strategy:  collisionNamespace,
+			manifests: []*outputManifestStruct{serviceAccount("a"), serviceAccount("b")},
+			want:      []string{"out/sa-app-a.yaml", "out/sa-app-b.yaml"},
+		},
+		{
+			name:      "cluster-scoped resources are told apart by group",
+			strategy:  collisionNamespace + "," + collisionGroup,
+			manifests: clusterScoped(),
+			want:      []string{"out/cr-view-rbac.authorization.k8s.io.yaml", "out/cr-view-example.com.yaml"},
+		},
+		{
+			name:      "cluster-scoped resources are told apart by hash",
+			strategy:  collisionNamespace + "," + collisionHash,
+			manifests: clusterScoped(),
+			// The hash covers the identity of the resource, so it is the same in every run
+			want: []string{"out/cr-view-548a78fb.yaml", "out/cr-view-14147a72.yaml"},
+		},
+		{
+			name:      "later strategies are used only while needed",
+			strategy:  collisionNamespace + "," + collisionHash,
+			manifests: []*outputManifestStruct{serviceAccount("a"), serviceAccount("b")},
+			want:      []string{"out/sa-app-a.yaml", "out/sa-app-b.yaml"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolveFilenames(test.manifests, &configStruct{CollisionStrategy: test.strategy})
+
+			var got []string
+			for _, manifest := range test.manifests {
+				got = append(got, manifest.Filename)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("filenames: got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// resolveFilenames exits when collisions remain, so it runs in a child process
+func TestResolveFilenamesListsConflicts(t *testing.T) {
+	if os.Getenv("TEST_RESOLVE_FILENAMES_EXIT") != "" {
+		resolveFilenames([]*outputManifestStruct{
+			{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Shortcut: "cr", Name: "view", Source: "templates/role.yaml", OutputDir: "out"},
+			{APIVersion: "example.com/v1", Kind: "ClusterRoleTemplate", Shortcut: "cr", Name: "view", Source: "templates/template.yaml", OutputDir: "out"},
+		}, &configStruct{CollisionStrategy: collisionNamespace})
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestResolveFilenamesListsConflicts$")
+	cmd.Env = append(os.Environ(), "TEST_RESOLVE_FILENAMES_EXIT=1")
+	output, err := cmd.CombinedOutput()
+
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Fatalf("exit status: got %v, want 1\n%s", err, output)
+	}
+	for _, want := range []string{
+		"ERROR! Several manifests would be written to the same file:\nout/cr-view.yaml:\n",
+		"  rbac.authorization.k8s.io/v1 ClusterRole/view (namespace: ) from templates/role.yaml\n",
+		"  example.com/v1 ClusterRoleTemplate/view (namespace: ) from templates/template.yaml\n",
+	} {
+		if !strings.Contains(string(output), want) {
+			t.Errorf("output does not contain %q:\n%s", want, output)
+		}
+	}
+}
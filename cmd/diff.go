@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+const diffContextLines = 3
+
+// A line of an edit script: ' ' kept, '-' removed, '+' added
+type diffLineStruct struct {
+	Op   byte
+	Text string
+}
+
+// Make a unified diff of two texts, empty if they are equal
+func unifiedDiff(oldName, newName string, oldText, newText []byte) string {
+	oldLines := splitLines(oldText)
+	newLines := splitLines(newText)
+	script := diffLines(oldLines, newLines)
+
+	var changes []int
+	for i, line := range script {
+		if line.Op != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "--- %v\n+++ %v\n", oldName, newName)
+
+	// Line numbers of every script entry in the old and new text
+	oldLineNumbers := make([]int, len(script)+1)
+	newLineNumbers := make([]int, len(script)+1)
+	for i, line := range script {
+		oldLineNumbers[i+1] = oldLineNumbers[i]
+		newLineNumbers[i+1] = newLineNumbers[i]
+		if line.Op != '+' {
+			oldLineNumbers[i+1]++
+		}
+		if line.Op != '-' {
+			newLineNumbers[i+1]++
+		}
+	}
+
+	// Group changes closer than two contexts into one hunk
+	for first := 0; first < len(changes); {
+		last := first
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*diffContextLines {
+			last++
+		}
+
+		start := max(changes[first]-diffContextLines, 0)
+		end := min(changes[last]+diffContextLines+1, len(script))
+
+		oldStart, oldCount := oldLineNumbers[start], oldLineNumbers[end]-oldLineNumbers[start]
+		newStart, newCount := newLineNumbers[start], newLineNumbers[end]-newLineNumbers[start]
+		if oldCount > 0 {
+			oldStart++
+		}
+		if newCount > 0 {
+			newStart++
+		}
+
+		fmt.Fprintf(&diff, "@@ -%v,%v +%v,%v @@\n", oldStart, oldCount, newStart, newCount)
+		for _, line := range script[start:end] {
+			fmt.Fprintf(&diff, "%c%v\n", line.Op, line.Text)
+		}
+
+		first = last + 1
+	}
+
+	return diff.String()
+}
+
+func splitLines(text []byte) []string {
+	if len(text) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(text), "\n"), "\n")
+}
+
+// Edit scripts longer than this are not searched for: the changed part is
+// shown as removed and added as a whole instead, the search would need
+// too much memory otherwise
+const diffMaxEditDistance = 1000
+
+// Make an edit script of two texts, keeping their common head and tail
+func diffLines(oldLines, newLines []string) []diffLineStruct {
+	head := 0
+	for head < len(oldLines) && head < len(newLines) && oldLines[head] == newLines[head] {
+		head++
+	}
+	tail := 0
+	for tail < len(oldLines)-head && tail < len(newLines)-head && oldLines[len(oldLines)-1-tail] == newLines[len(newLines)-1-tail] {
+		tail++
+	}
+
+	var script []diffLineStruct
+	for _, line := range oldLines[:head] {
+		script = append(script, diffLineStruct{Op: ' ', Text: line})
+	}
+	script = append(script, myersDiff(oldLines[head:len(oldLines)-tail], newLines[head:len(newLines)-tail])...)
+	for _, line := range oldLines[len(oldLines)-tail:] {
+		script = append(script, diffLineStruct{Op: ' ', Text: line})
+	}
+	return script
+}
+
+// Remove all old lines and add all new ones
+func replaceLines(oldLines, newLines []string) []diffLineStruct {
+	script := make([]diffLineStruct, 0, len(oldLines)+len(newLines))
+	for _, line := range oldLines {
+		script = append(script, diffLineStruct{Op: '-', Text: line})
+	}
+	for _, line := range newLines {
+		script = append(script, diffLineStruct{Op: '+', Text: line})
+	}
+	return script
+}
+
+// Find the shortest edit script with the Myers algorithm. Step d only
+// reaches diagonals -d..d, so only those are kept for walking back
+func myersDiff(oldLines, newLines []string) []diffLineStruct {
+	n, m := len(oldLines), len(newLines)
+	if n == 0 || m == 0 {
+		return replaceLines(oldLines, newLines)
+	}
+
+	// trace[d][k+d] is the furthest x on diagonal k after d edits
+	var trace [][]int
+	found := false
+
+search:
+	for d := 0; d <= min(n+m, diffMaxEditDistance); d++ {
+		v := make([]int, 2*d+1)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if d > 0 {
+				previous := trace[d-1]
+				if k == -d || (k != d && previous[k-1+d-1] < previous[k+1+d-1]) {
+					x = previous[k+1+d-1]
+				} else {
+					x = previous[k-1+d-1] + 1
+				}
+			}
+			y := x - k
+			for x < n && y < m && oldLines[x] == newLines[y] {
+				x++
+				y++
+			}
+			v[k+d] = x
+			if x >= n && y >= m {
+				trace = append(trace, v)
+				found = true
+				break search
+			}
+		}
+		trace = append(trace, v)
+	}
+	if !found {
+		return replaceLines(oldLines, newLines)
+	}
+
+	// Walk back from the end to restore the script
+	var reversed []diffLineStruct
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		previous := trace[d-1]
+		k := x - y
+
+		var previousK int
+		if k == -d || (k != d && previous[k-1+d-1] < previous[k+1+d-1]) {
+			previousK = k + 1
+		} else {
+			previousK = k - 1
+		}
+		previousX := previous[previousK+d-1]
+		previousY := previousX - previousK
+
+		for x > previousX && y > previousY {
+			x--
+			y--
+			reversed = append(reversed, diffLineStruct{Op: ' ', Text: oldLines[x]})
+		}
+
+		if x == previousX {
+			reversed = append(reversed, diffLineStruct{Op: '+', Text: newLines[previousY]})
+		} else {
+			reversed = append(reversed, diffLineStruct{Op: '-', Text: oldLines[previousX]})
+		}
+		x, y = previousX, previousY
+	}
+	for x > 0 {
+		x--
+		reversed = append(reversed, diffLineStruct{Op: ' ', Text: oldLines[x]})
+	}
+
+	script := make([]diffLineStruct, len(reversed))
+	for i, line := range reversed {
+		script[len(reversed)-1-i] = line
+	}
+	return script
+}
+
+// Print a diff of what a real run would change in the output directory,
+// without writing anything. Returns true if there are any changes
+func diffManifests(manifests []*outputManifestStruct, outputDir string) bool {
+	var added, modified, removed int
+
+	for _, manifest := range manifests {
+		var oldName string
+		var oldContent []byte
+
+		if fileIsAbsent(manifest.Filename) {
+			oldName = "/dev/null"
+			added++
+		} else {
+			var err error
+			oldContent, err = os.ReadFile(manifest.Filename)
+			checkErr(err)
+			oldName = manifest.Filename
+		}
+
+		diff := unifiedDiff(oldName, manifest.Filename, oldContent, manifest.Content)
+		if diff != "" && oldName != "/dev/null" {
+			modified++
+		}
+		fmt.Print(diff)
+	}
+
+	if prune {
+		for _, file := range staleFiles(manifests, outputDir) {
+			filename := path.Join(outputDir, file)
+			oldContent, err := os.ReadFile(filename)
+			checkErr(err)
+
+			fmt.Print(unifiedDiff(filename, "/dev/null", oldContent, nil))
+			removed++
+		}
+	}
+
+	if added+modified+removed == 0 {
+		fmt.Println("No changes")
+		return false
+	}
+
+	fmt.Printf("%v added, %v modified, %v removed\n", added, modified, removed)
+	return true
+}
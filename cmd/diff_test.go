@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		want    string
+	}{
+		{
+			name:    "equal",
+			oldText: "a\nb\n",
+			newText: "a\nb\n",
+			want:    "",
+		},
+		{
+			name:    "added file",
+			oldText: "",
+			newText: "a\nb\n",
+			want:    "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:    "removed file",
+			oldText: "a\nb\n",
+			newText: "",
+			want:    "--- old\n+++ new\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name:    "changed line with context",
+			oldText: "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			newText: "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want:    "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name:    "distant changes in separate hunks",
+			oldText: "a\n1\n2\n3\n4\n5\n6\n7\nb\n",
+			newText: "A\n1\n2\n3\n4\n5\n6\n7\nB\n",
+			want:    "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -6,4 +6,4 @@\n 5\n 6\n 7\n-b\n+B\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := unifiedDiff("old", "new", []byte(test.oldText), []byte(test.newText)); got != test.want {
+				t.Errorf("got:\n%v\nwant:\n%v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesIsShortest(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		oldLines := randomLines(random, random.Intn(20))
+		newLines := randomLines(random, random.Intn(20))
+
+		script := diffLines(oldLines, newLines)
+		checkScript(t, script, oldLines, newLines)
+
+		edits := 0
+		for _, line := range script {
+			if line.Op != ' ' {
+				edits++
+			}
+		}
+		if want := len(oldLines) + len(newLines) - 2*longestCommonSubsequence(oldLines, newLines); edits != want {
+			t.Fatalf("diff of %q and %q has %v edits, want %v", oldLines, newLines, edits, want)
+		}
+	}
+}
+
+func TestDiffLinesOfLargeFiles(t *testing.T) {
+	var lines []string
+	for i := 0; i < 20000; i++ {
+		lines = append(lines, fmt.Sprintf("line %v", i))
+	}
+
+	script := diffLines(nil, lines)
+	checkScript(t, script, nil, lines)
+
+	// Every other line changed: more edits than are searched for
+	changed := append([]string(nil), lines...)
+	for i := 0; i < len(changed); i += 2 {
+		changed[i] += " changed"
+	}
+	checkScript(t, diffLines(lines, changed), lines, changed)
+}
+
+// The script must turn the old lines into the new ones
+func checkScript(t *testing.T, script []diffLineStruct, oldLines, newLines []string) {
+	t.Helper()
+
+	var gotOld, gotNew []string
+	for _, line := range script {
+		if line.Op != '+' {
+			gotOld = append(gotOld, line.Text)
+		}
+		if line.Op != '-' {
+			gotNew = append(gotNew, line.Text)
+		}
+	}
+	if !reflect.DeepEqual(gotOld, oldLines) || !reflect.DeepEqual(gotNew, newLines) {
+		t.Fatalf("script %v does not turn %q into %q", script, strings.Join(oldLines, ","), strings.Join(newLines, ","))
+	}
+}
+
+func randomLines(random *rand.Rand, count int) []string {
+	var lines []string
+	for i := 0; i < count; i++ {
+		lines = append(lines, string(rune('a'+random.Intn(4))))
+	}
+	return lines
+}
+
+func longestCommonSubsequence(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+	return lengths[0][0]
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+const defaultFilenameTemplate = "{{.Subchart}}/{{.Shortcut}}-{{.Name}}.yaml"
+
+// Fields available in filename templates
+type filenameFieldsStruct struct {
+	Release    string
+	Kind       string
+	Shortcut   string
+	Name       string
+	Namespace  string
+	APIVersion string
+	Group      string
+	Subchart   string // subdirectory of templates/ or crds/, empty for the chart root
+	Source     string // rendered template, e.g. templates/deployment.yaml
+}
+
+var filenameTemplateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"kebab": kebabCase,
+}
+
+func parseFilenameTemplate(text string) *template.Template {
+	if text == "" {
+		text = defaultFilenameTemplate
+	}
+
+	filenameTemplate, err := template.New("filename").Funcs(filenameTemplateFuncs).Parse(text)
+	if err != nil {
+		fmt.Printf("ERROR! Invalid filename template: %v\n", err)
+		exit(1)
+	}
+
+	return filenameTemplate
+}
+
+// Render the output filename of a manifest. The result is relative
+// to the output directory and must stay inside it
+func manifestFilename(filenameTemplate *template.Template, manifest *outputManifestStruct) string {
+	fields := filenameFieldsStruct{
+		Release:    manifest.Release,
+		Kind:       manifest.Kind,
+		Shortcut:   manifest.Shortcut,
+		Name:       manifest.Name + manifest.Suffix,
+		Namespace:  manifest.Namespace,
+		APIVersion: manifest.APIVersion,
+		Group:      apiGroup(manifest.APIVersion),
+		Subchart:   manifest.Subchart,
+		Source:     manifest.Source,
+	}
+
+	var filename bytes.Buffer
+	if err := filenameTemplate.Execute(&filename, fields); err != nil {
+		fmt.Printf("ERROR! Cannot render filename template for %v/%v: %v\n", manifest.Kind, manifest.Name, err)
+		exit(1)
+	}
+
+	relPath := path.Clean("/" + strings.TrimSpace(filename.String()))
+	if relPath == "/" || strings.Contains(filename.String(), "..") {
+		fmt.Printf("ERROR! Filename template gives an invalid filename \"%v\" for %v/%v!\n", filename.String(), manifest.Kind, manifest.Name)
+		exit(1)
+	}
+
+	return path.Join(manifest.OutputDir, relPath)
+}
+
+// Convert CamelCase and snake_case to kebab-case, e.g. APIService -> api-service
+func kebabCase(text string) string {
+	runes := []rune(text)
+	var result strings.Builder
+
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == ' ':
+			result.WriteRune('-')
+			continue
+		case unicode.IsUpper(r) && i > 0:
+			previous := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+				result.WriteRune('-')
+			}
+		}
+		result.WriteRune(unicode.ToLower(r))
+	}
+
+	return result.String()
+}
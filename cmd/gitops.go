@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const gitOpsNone = "none"
+const gitOpsArgoCD = "argocd"
+const gitOpsFlux = "flux"
+
+const syncPolicyManual = "manual"
+const syncPolicyAuto = "auto"
+const syncPolicyAutoPrune = "auto-prune"
+
+const defaultFluxNamespace = "flux-system"
+const defaultArgoCDNamespace = "argocd"
+const defaultFluxSource = "GitRepository/flux-system"
+const defaultFluxInterval = "10m"
+const defaultArgoCDProject = "default"
+const defaultArgoCDRevision = "HEAD"
+const defaultDestinationServer = "https://kubernetes.default.svc"
+
+// Settings of the generated GitOps resources, the "gitops" section of the config
+type GitOpsStruct struct {
+	Generate          string `yaml:"generate,omitempty"`          // "argocd" or "flux" resource for every output directory
+	Namespace         string `yaml:"namespace,omitempty"`         // where the generated resources live
+	PathPrefix        string `yaml:"pathPrefix,omitempty"`        // current directory relative to the repository root
+	TargetNamespace   string `yaml:"targetNamespace,omitempty"`   // Argo CD: the release namespace by default
+	SyncPolicy        string `yaml:"syncPolicy,omitempty"`        // manual, auto or auto-prune
+	RepoURL           string `yaml:"repoURL,omitempty"`           // Argo CD source repository
+	Revision          string `yaml:"revision,omitempty"`          // Argo CD target revision
+	Project           string `yaml:"project,omitempty"`           // Argo CD project
+	DestinationServer string `yaml:"destinationServer,omitempty"` // Argo CD destination cluster
+	Source            string `yaml:"source,omitempty"`            // Flux source with the output directories, <Kind>/<name>
+	Interval          string `yaml:"interval,omitempty"`          // Flux reconciliation interval
+}
+
+var gitOpsFlags GitOpsStruct
+
+type ArgoCDApplicationStruct struct {
+	APIVersion string                      `yaml:"apiVersion"`
+	Kind       string                      `yaml:"kind"`
+	Metadata   GitOpsMetadataStruct        `yaml:"metadata"`
+	Spec       ArgoCDApplicationSpecStruct `yaml:"spec"`
+}
+
+type ArgoCDApplicationSpecStruct struct {
+	Project     string                  `yaml:"project"`
+	Source      ArgoCDSourceStruct      `yaml:"source"`
+	Destination ArgoCDDestinationStruct `yaml:"destination"`
+	SyncPolicy  *ArgoCDSyncPolicyStruct `yaml:"syncPolicy,omitempty"`
+}
+
+type ArgoCDSourceStruct struct {
+	RepoURL        string                 `yaml:"repoURL"`
+	TargetRevision string                 `yaml:"targetRevision"`
+	Path           string                 `yaml:"path"`
+	Directory      *ArgoCDDirectoryStruct `yaml:"directory,omitempty"`
+}
+
+type ArgoCDDirectoryStruct struct {
+	Recurse bool   `yaml:"recurse"`
+	Exclude string `yaml:"exclude,omitempty"`
+}
+
+type ArgoCDDestinationStruct struct {
+	Server    string `yaml:"server"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type ArgoCDSyncPolicyStruct struct {
+	Automated ArgoCDAutomatedStruct `yaml:"automated"`
+}
+
+type ArgoCDAutomatedStruct struct {
+	Prune    bool `yaml:"prune,omitempty"`
+	SelfHeal bool `yaml:"selfHeal,omitempty"`
+}
+
+type FluxKustomizationStruct struct {
+	APIVersion string                      `yaml:"apiVersion"`
+	Kind       string                      `yaml:"kind"`
+	Metadata   GitOpsMetadataStruct        `yaml:"metadata"`
+	Spec       FluxKustomizationSpecStruct `yaml:"spec"`
+}
+
+type GitOpsMetadataStruct struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+}
+
+type FluxKustomizationSpecStruct struct {
+	Interval        string                 `yaml:"interval"`
+	Path            string                 `yaml:"path"`
+	Prune           bool                   `yaml:"prune"`
+	Wait            bool                   `yaml:"wait"`
+	Force           bool                   `yaml:"force,omitempty"`
+	Suspend         bool                   `yaml:"suspend,omitempty"`
+	TargetNamespace string                 `yaml:"targetNamespace,omitempty"`
+	SourceRef       FluxSourceRefStruct    `yaml:"sourceRef"`
+	DependsOn       []FluxDependencyStruct `yaml:"dependsOn,omitempty"`
+}
+
+type FluxSourceRefStruct struct {
+	Kind string `yaml:"kind"`
+	Name string `yaml:"name"`
+}
+
+type FluxDependencyStruct struct {
+	Name string `yaml:"name"`
+}
+
+// GitOps settings: the defaults, then the config, then the flags
+func gitOpsSettings(config *configStruct, flags *GitOpsStruct) GitOpsStruct {
+	settings := GitOpsStruct{
+		Generate:          gitOpsNone,
+		SyncPolicy:        syncPolicyAutoPrune,
+		Revision:          defaultArgoCDRevision,
+		Project:           defaultArgoCDProject,
+		DestinationServer: defaultDestinationServer,
+		Source:            defaultFluxSource,
+		Interval:          defaultFluxInterval,
+	}
+	for _, source := range []*GitOpsStruct{&config.GitOps, flags} {
+		for target, value := range map[*string]string{
+			&settings.Generate:          source.Generate,
+			&settings.Namespace:         source.Namespace,
+			&settings.PathPrefix:        source.PathPrefix,
+			&settings.TargetNamespace:   source.TargetNamespace,
+			&settings.SyncPolicy:        source.SyncPolicy,
+			&settings.RepoURL:           source.RepoURL,
+			&settings.Revision:          source.Revision,
+			&settings.Project:           source.Project,
+			&settings.DestinationServer: source.DestinationServer,
+			&settings.Source:            source.Source,
+			&settings.Interval:          source.Interval,
+		} {
+			if value != "" {
+				*target = value
+			}
+		}
+	}
+
+	switch settings.Generate {
+	case gitOpsNone, gitOpsFlux:
+	case gitOpsArgoCD:
+		if settings.RepoURL == "" {
+			fmt.Println("ERROR! Argo CD Applications need a repository URL, set \"--gitops-repo-url\" or \"repoURL\" in the \"gitops\" section of the config!")
+			exit(1)
+		}
+		if config.HookTransform == hookTransformFlux {
+			fmt.Println("ERROR! Flux hook phases cannot be deployed with Argo CD Applications!")
+			exit(1)
+		}
+	default:
+		fmt.Printf("ERROR! Unknown GitOps resource \"%v\", must be \"%v\", \"%v\" or \"%v\"!\n", settings.Generate, gitOpsNone, gitOpsArgoCD, gitOpsFlux)
+		exit(1)
+	}
+
+	if settings.SyncPolicy != syncPolicyManual && settings.SyncPolicy != syncPolicyAuto && settings.SyncPolicy != syncPolicyAutoPrune {
+		fmt.Printf("ERROR! Unknown sync policy \"%v\", must be \"%v\", \"%v\" or \"%v\"!\n", settings.SyncPolicy, syncPolicyManual, syncPolicyAuto, syncPolicyAutoPrune)
+		exit(1)
+	}
+
+	if kind, name, found := strings.Cut(settings.Source, "/"); !found || kind == "" || name == "" {
+		fmt.Printf("ERROR! Flux source \"%v\" must look like <Kind>/<name>, e.g. %v!\n", settings.Source, defaultFluxSource)
+		exit(1)
+	}
+
+	if settings.Namespace == "" {
+		settings.Namespace = defaultFluxNamespace
+		if settings.Generate == gitOpsArgoCD {
+			settings.Namespace = defaultArgoCDNamespace
+		}
+	}
+	return settings
+}
+
+// Whether the output directories are applied by Flux
+func usesFlux(config *configStruct) bool {
+	return config.HookTransform == hookTransformFlux || config.GitOps.Generate == gitOpsFlux
+}
+
+// Argo CD Application of a release, written next to its output directory
+func argoCDApplication(manifests []*outputManifestStruct, release *releaseStruct, settings *GitOpsStruct) *outputManifestStruct {
+	outputDir := path.Clean(release.OutputDir)
+
+	application := ArgoCDApplicationStruct{
+		APIVersion: "argoproj.io/v1alpha1",
+		Kind:       "Application",
+		Metadata:   GitOpsMetadataStruct{Name: release.Name, Namespace: settings.Namespace},
+		Spec: ArgoCDApplicationSpecStruct{
+			Project: settings.Project,
+			Source: ArgoCDSourceStruct{
+				RepoURL:        settings.RepoURL,
+				TargetRevision: settings.Revision,
+				Path:           path.Join(settings.PathPrefix, outputDir),
+			},
+			Destination: ArgoCDDestinationStruct{
+				Server:    settings.DestinationServer,
+				Namespace: gitOpsTargetNamespace(release, settings),
+			},
+		},
+	}
+
+	// Without kustomization.yaml Argo CD reads only the top directory.
+	// Hooks in their own subdirectory are applied on demand, not synced
+	if !release.Kustomization.Enabled {
+		application.Spec.Source.Directory = &ArgoCDDirectoryStruct{Recurse: true}
+		for _, manifest := range manifests {
+			if path.Clean(manifest.OutputDir) == path.Join(outputDir, hooksDir) {
+				application.Spec.Source.Directory.Exclude = hooksDir + "/*"
+				break
+			}
+		}
+	}
+
+	switch settings.SyncPolicy {
+	case syncPolicyAuto:
+		application.Spec.SyncPolicy = &ArgoCDSyncPolicyStruct{}
+	case syncPolicyAutoPrune:
+		application.Spec.SyncPolicy = &ArgoCDSyncPolicyStruct{Automated: ArgoCDAutomatedStruct{Prune: true, SelfHeal: true}}
+	}
+
+	return &outputManifestStruct{
+		Release:  release.Name,
+		Kind:     application.Kind,
+		Filename: outputDir + "-application.yaml",
+		Content:  append([]byte("---\n"), marshalYaml(&application)...),
+	}
+}
+
+func gitOpsTargetNamespace(release *releaseStruct, settings *GitOpsStruct) string {
+	if settings.TargetNamespace != "" {
+		return settings.TargetNamespace
+	}
+	return release.Namespace
+}
+
+// Flux Kustomizations of a release, written next to its output directory.
+// Hooks moved to the pre and post phases get their own Kustomizations,
+// the release depends on the pre hooks and the post hooks depend on the release
+func fluxKustomizations(manifests []*outputManifestStruct, release *releaseStruct, settings *GitOpsStruct) *outputManifestStruct {
+	outputDir := path.Clean(release.OutputDir)
+	phases := map[string]bool{}
+	forced := map[string]bool{}
+	for _, manifest := range manifests {
+		for _, phase := range []string{fluxPhasePre, fluxPhasePost} {
+			if path.Clean(manifest.OutputDir) != path.Join(outputDir, hooksDir, phase) {
+				continue
+			}
+			phases[phase] = true
+			// Jobs are immutable, Flux has to recreate them like helm does
+			if strings.Contains(manifestAnnotation(manifest, hookDeletePolicyAnnotation), "before-hook-creation") {
+				forced[phase] = true
+			}
+		}
+	}
+
+	var content []byte
+	var dependsOn string
+	for _, phase := range []string{fluxPhasePre, "", fluxPhasePost} {
+		name, dir := release.Name, outputDir
+		if phase != "" {
+			if !phases[phase] {
+				continue
+			}
+			name, dir = release.Name+"-"+phase, path.Join(outputDir, hooksDir, phase)
+		}
+
+		kustomization := fluxKustomization(name, dir, settings)
+		kustomization.Spec.Force = forced[phase]
+		if dependsOn != "" {
+			kustomization.Spec.DependsOn = []FluxDependencyStruct{{Name: dependsOn}}
+		}
+		dependsOn = name
+
+		content = append(content, "---\n"...)
+		content = append(content, marshalYaml(&kustomization)...)
+	}
+
+	return &outputManifestStruct{
+		Release:  release.Name,
+		Kind:     "Kustomization",
+		Filename: outputDir + "-flux.yaml",
+		Content:  content,
+	}
+}
+
+func fluxKustomization(name, dir string, settings *GitOpsStruct) FluxKustomizationStruct {
+	sourceKind, sourceName, _ := strings.Cut(settings.Source, "/")
+	// Flux paths are relative to the root of the source
+	dir = path.Join(settings.PathPrefix, dir)
+	if !path.IsAbs(dir) {
+		dir = "./" + dir
+	}
+
+	// Unlike the destination of Argo CD, targetNamespace overrides the namespace
+	// of every namespaced resource, so it is not defaulted to the release namespace
+	return FluxKustomizationStruct{
+		APIVersion: "kustomize.toolkit.fluxcd.io/v1",
+		Kind:       "Kustomization",
+		Metadata:   GitOpsMetadataStruct{Name: name, Namespace: settings.Namespace},
+		Spec: FluxKustomizationSpecStruct{
+			Interval:        settings.Interval,
+			Path:            dir,
+			Prune:           settings.SyncPolicy == syncPolicyAutoPrune,
+			Wait:            true,
+			Suspend:         settings.SyncPolicy == syncPolicyManual,
+			TargetNamespace: settings.TargetNamespace,
+			SourceRef:       FluxSourceRefStruct{Kind: sourceKind, Name: sourceName},
+		},
+	}
+}
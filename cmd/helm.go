@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os/exec"
+	"sync"
+)
+
+// HelmRunner runs helm commands. Every argument is passed to helm as a
+// separate argv entry, so paths and values containing spaces are safe
+type HelmRunner interface {
+	RepoAdd(name, url string) error
+	RepoUpdate() error
+	Pull(chartRef string, options PullOptions) error
+	DependencyBuild(chartDir string) error
+	Template(releaseName, chartDir string, options TemplateOptions) error
+}
+
+type PullOptions struct {
+	Version string
+	DestDir string // where the chart archive is saved
+}
+
+type TemplateOptions struct {
+	Namespace   string
+	ValuesFiles []string
+	SetValues   []setValueStruct
+	KubeVersion string
+	APIVersions []string
+	IncludeCRDs bool
+	OutputDir   string
+}
+
+// Adding a repository, updating it, pulling a chart from it and building
+// dependencies (which refreshes the repositories) use the user's repository
+// list, so releases rendered at once take turns to run them
+var helmRepoLock sync.Mutex
+
+// execHelmRunner runs a helm binary found in PATH (or a custom one)
+type execHelmRunner struct {
+	binary string
+}
+
+func newExecHelmRunner(binary string) *execHelmRunner {
+	if binary == "" {
+		binary = "helm"
+	}
+	return &execHelmRunner{binary: binary}
+}
+
+func (h *execHelmRunner) RepoAdd(name, url string) error {
+	return h.run("repo", "add", name, url)
+}
+
+func (h *execHelmRunner) RepoUpdate() error {
+	return h.run("repo", "update")
+}
+
+func (h *execHelmRunner) Pull(chartRef string, options PullOptions) error {
+	args := []string{"pull", chartRef, "--destination", options.DestDir}
+	if options.Version != "" {
+		args = append(args, "--version", options.Version)
+	}
+	return h.run(args...)
+}
+
+func (h *execHelmRunner) DependencyBuild(chartDir string) error {
+	return h.run("dependency", "build", chartDir)
+}
+
+func (h *execHelmRunner) Template(releaseName, chartDir string, options TemplateOptions) error {
+	args := []string{"template", releaseName, chartDir, "--namespace", options.Namespace, "--output-dir", options.OutputDir}
+	for _, valuesFile := range options.ValuesFiles {
+		args = append(args, "--values", valuesFile)
+	}
+	for _, setValue := range options.SetValues {
+		args = append(args, "--"+setValue.Flag, setValue.Value)
+	}
+	if options.KubeVersion != "" {
+		args = append(args, "--kube-version", options.KubeVersion)
+	}
+	for _, apiVersion := range options.APIVersions {
+		args = append(args, "--api-versions", apiVersion)
+	}
+	if options.IncludeCRDs {
+		args = append(args, "--include-crds")
+	}
+	return h.run(args...)
+}
+
+func (h *execHelmRunner) run(args ...string) error {
+	printDebug("Running command: %q\n", append([]string{h.binary}, args...))
+
+	output, err := exec.Command(h.binary, args...).CombinedOutput()
+
+	printDebug("%s", output)
+	if err != nil {
+		return fmt.Errorf("%v %v: %w\n%s", h.binary, args[0], err, output)
+	}
+	return nil
+}
+
+// Name of a repository in the user's repository list. It is derived from
+// the URL, so charts of the same name from different repositories do not clash
+func helmRepoAlias(repository string) string {
+	return "helm-splitter-" + fmt.Sprintf("%x", sha256.Sum256([]byte(repository)))[:8]
+}
+
+func exitOnHelmErr(err error) {
+	if err != nil {
+		fmt.Println("ERROR!", err)
+		exit(1)
+	}
+}
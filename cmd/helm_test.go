@@ -0,0 +1,194 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Fake helm binary: logs every call as a line of [arg] entries and
+// "pulls" the archive from $FAKE_HELM_CHART into --destination
+const fakeHelmScript = `#!/bin/sh
+printf '[%s]' "$@" >> "$FAKE_HELM_LOG"
+echo >> "$FAKE_HELM_LOG"
+if [ "$1" = pull ]; then
+	while [ $# -gt 0 ]; do
+		if [ "$1" = --destination ]; then
+			cp "$FAKE_HELM_CHART" "$2/"
+		fi
+		shift
+	done
+fi
+`
+
+func TestExecHelmCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake helm binary is a shell script")
+	}
+
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "helm")
+	writeTestFile(t, binary, fakeHelmScript)
+	if err := os.Chmod(binary, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	chartArchive := filepath.Join(dir, "demo-1.2.3.tgz")
+	writeTestChartArchive(t, chartArchive, "demo", "name: demo\nversion: 1.2.3\n")
+	t.Setenv("FAKE_HELM_CHART", chartArchive)
+
+	localChart := filepath.Join(dir, "local chart")
+	writeTestFile(t, filepath.Join(localChart, "Chart.yaml"), "name: demo\nversion: 0.1.0\n")
+
+	tests := []struct {
+		name    string
+		release releaseStruct
+		argv    func(tmpDir string) [][]string
+	}{
+		{
+			name: "repository chart",
+			release: releaseStruct{
+				Name: "demo", Chart: "demo", Repository: "https://charts.example.com", Namespace: "ns", Version: "1.2.3",
+				ValuesFiles: []string{"my values.yaml"},
+				SetValues:   []setValueStruct{{Flag: setFlag, Value: "greeting=hello world"}, {Flag: setStringFlag, Value: "tag=1.0"}},
+			},
+			argv: func(tmpDir string) [][]string {
+				return [][]string{
+					{"repo", "add", helmRepoAlias("https://charts.example.com"), "https://charts.example.com"},
+					{"repo", "update"},
+					{"pull", helmRepoAlias("https://charts.example.com") + "/demo", "--destination", tmpDir, "--version", "1.2.3"},
+					{"template", "demo", tmpDir + "/demo", "--namespace", "ns", "--output-dir", tmpDir + "/rendered",
+						"--values", "my values.yaml", "--set", "greeting=hello world", "--set-string", "tag=1.0", "--include-crds"},
+				}
+			},
+		},
+		{
+			name: "OCI chart",
+			release: releaseStruct{
+				Name: "web", Chart: "demo", ChartRef: "oci://registry.example.com/charts/demo", Namespace: "ns",
+				KubeVersion: "1.29.0", APIVersions: apiVersionsValue{"policy/v1/PodDisruptionBudget"}, SkipCRDs: true,
+			},
+			argv: func(tmpDir string) [][]string {
+				return [][]string{
+					{"pull", "oci://registry.example.com/charts/demo", "--destination", tmpDir},
+					{"template", "web", tmpDir + "/demo", "--namespace", "ns", "--output-dir", tmpDir + "/rendered",
+						"--kube-version", "1.29.0", "--api-versions", "policy/v1/PodDisruptionBudget"},
+				}
+			},
+		},
+		{
+			name: "local chart",
+			release: releaseStruct{
+				Name: "demo", Chart: "demo", ChartPath: localChart, Namespace: "ns",
+				ValuesFiles: []string{filepath.Join(dir, "values dir", "prod values.yaml")},
+				SetValues:   []setValueStruct{{Flag: setFileFlag, Value: "script=" + filepath.Join(dir, "my script.sh")}},
+			},
+			argv: func(tmpDir string) [][]string {
+				return [][]string{
+					{"template", "demo", tmpDir + "/demo", "--namespace", "ns", "--output-dir", tmpDir + "/rendered",
+						"--values", filepath.Join(dir, "values dir", "prod values.yaml"), "--set-file", "script=" + filepath.Join(dir, "my script.sh"), "--include-crds"},
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := filepath.Join(t.TempDir(), "helm.log")
+			t.Setenv("FAKE_HELM_LOG", log)
+
+			release := test.release
+			release.TmpDir = t.TempDir()
+			execHelmCommands(newExecHelmRunner(binary), &release)
+
+			if got, want := readHelmLog(t, log), test.argv(release.TmpDir); !reflect.DeepEqual(got, want) {
+				t.Errorf("helm calls:\n got %q\nwant %q", got, want)
+			}
+			if release.ResolvedVersion == "" {
+				t.Errorf("resolved version is not set")
+			}
+		})
+	}
+}
+
+// Calls logged by the fake helm binary, one argv per call
+func readHelmLog(t *testing.T, log string) [][]string {
+	content, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls [][]string
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		line = strings.TrimSuffix(strings.TrimPrefix(line, "["), "]")
+		calls = append(calls, strings.Split(line, "]["))
+	}
+	return calls
+}
+
+func writeTestFile(t *testing.T, filename, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Write a chart archive with a single Chart.yaml, the way "helm package" lays it out
+func writeTestChartArchive(t *testing.T, filename, chartName, chartYaml string) {
+	t.Helper()
+	writeTestChartArchiveFiles(t, filename, chartName, map[string]string{"Chart.yaml": chartYaml})
+}
+
+// Write a chart archive with the given files, relative to the chart root
+func writeTestChartArchiveFiles(t *testing.T, filename, chartName string, files map[string]string) {
+	t.Helper()
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, name := range names {
+		err = tarWriter.WriteHeader(&tar.Header{Name: chartName + "/" + name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg})
+		if err == nil {
+			_, err = tarWriter.Write([]byte(files[name]))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tarWriter.Close()
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHelmRepoAlias(t *testing.T) {
+	first, second := helmRepoAlias("https://charts.bitnami.com/bitnami"), helmRepoAlias("https://charts.example.com")
+	if first == second {
+		t.Errorf("repositories with different URLs share the alias %v", first)
+	}
+	if first != helmRepoAlias("https://charts.bitnami.com/bitnami") {
+		t.Errorf("alias of a repository is not stable")
+	}
+}
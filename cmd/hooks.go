@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+const hookAnnotation = "helm.sh/hook"
+
+const hookPolicyDrop = "drop"
+const hookPolicySubdir = "subdir"
+const hookPolicyKeep = "keep"
+
+// Hooks written with the subdir policy go here, relative to the output directory
+const hooksDir = "hooks"
+
+// Policy for hook types not listed explicitly
+const hookDefault = "default"
+
+var hookFlags hookPoliciesValue
+
+var hookTypes = []string{
+	"pre-install", "post-install",
+	"pre-delete", "post-delete",
+	"pre-upgrade", "post-upgrade",
+	"pre-rollback", "post-rollback",
+	"test",
+}
+
+// Test pods must not be applied by GitOps tools, other hooks are kept as is
+var defaultHookPolicies = map[string]string{
+	hookDefault: hookPolicyKeep,
+	"test":      hookPolicyDrop,
+}
+
+func validateHookPolicies(policies map[string]string) {
+	for hookType, policy := range policies {
+		if hookType != hookDefault && !isHookType(hookType) {
+			fmt.Printf("ERROR! Unknown hook type \"%v\", must be \"%v\" or one of %v!\n", hookType, hookDefault, strings.Join(hookTypes, ", "))
+			exit(1)
+		}
+		if policy != hookPolicyDrop && policy != hookPolicySubdir && policy != hookPolicyKeep {
+			fmt.Printf("ERROR! Unknown policy \"%v\" of %v hooks, must be \"%v\", \"%v\" or \"%v\"!\n", policy, hookType, hookPolicyDrop, hookPolicySubdir, hookPolicyKeep)
+			exit(1)
+		}
+	}
+}
+
+func isHookType(hookType string) bool {
+	for _, knownType := range hookTypes {
+		if knownType == hookType {
+			return true
+		}
+	}
+	return false
+}
+
+// Hook types of a manifest from its helm.sh/hook annotation,
+// "test-success" and "test-failure" are old names of "test"
+func parseHookTypes(annotation string) []string {
+	var types []string
+	for _, hookType := range strings.Split(annotation, ",") {
+		hookType = strings.TrimSpace(hookType)
+		if hookType == "test-success" || hookType == "test-failure" {
+			hookType = "test"
+		}
+		if hookType != "" {
+			types = append(types, hookType)
+		}
+	}
+	return types
+}
+
+// Policy of every hook type: the defaults, then the config, then --hooks
+func hookPolicies(config *configStruct, flagPolicies map[string]string) map[string]string {
+	policies := map[string]string{}
+	for _, source := range []map[string]string{defaultHookPolicies, config.Hooks, flagPolicies} {
+		for hookType, policy := range source {
+			policies[hookType] = policy
+		}
+	}
+	validateHookPolicies(policies)
+	return policies
+}
+
+// Drop hooks or move them to the hooks/ subdirectory of the output directory.
+// A hook of several types gets the policy which keeps the most of it
+func applyHookPolicies(manifests []*outputManifestStruct, policies map[string]string) []*outputManifestStruct {
+	var kept []*outputManifestStruct
+
+	for _, manifest := range manifests {
+		if len(manifest.Hooks) == 0 {
+			kept = append(kept, manifest)
+			continue
+		}
+
+		policy := hookPolicyDrop
+		for _, hookType := range manifest.Hooks {
+			typePolicy, found := policies[hookType]
+			if !found {
+				typePolicy = policies[hookDefault]
+			}
+			if hookPolicyRank(typePolicy) > hookPolicyRank(policy) {
+				policy = typePolicy
+			}
+		}
+		printDebug("Hook %v/%v (%v): %v\n", manifest.Kind, manifest.Name, strings.Join(manifest.Hooks, ","), policy)
+
+		switch policy {
+		case hookPolicyDrop:
+			continue
+		case hookPolicySubdir:
+			manifest.OutputDir = path.Join(manifest.OutputDir, hooksDir)
+		}
+		kept = append(kept, manifest)
+	}
+
+	return kept
+}
+
+func hookPolicyRank(policy string) int {
+	switch policy {
+	case hookPolicyKeep:
+		return 2
+	case hookPolicySubdir:
+		return 1
+	}
+	return 0
+}
+
+// hookPoliciesValue is a flag.Value for "type=policy,type=policy" lists
+type hookPoliciesValue map[string]string
+
+func (h *hookPoliciesValue) String() string {
+	var policies []string
+	for hookType, policy := range *h {
+		policies = append(policies, hookType+"="+policy)
+	}
+	sort.Strings(policies)
+	return strings.Join(policies, ",")
+}
+
+func (h *hookPoliciesValue) Set(text string) error {
+	if *h == nil {
+		*h = hookPoliciesValue{}
+	}
+	for _, item := range strings.Split(text, ",") {
+		hookType, policy, found := strings.Cut(item, "=")
+		if !found || strings.TrimSpace(hookType) == "" {
+			return fmt.Errorf("hook policy \"%v\" must look like type=policy", item)
+		}
+		(*h)[strings.TrimSpace(hookType)] = strings.TrimSpace(policy)
+	}
+	return nil
+}
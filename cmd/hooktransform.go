@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const hookTransformNone = "none"
+const hookTransformArgoCD = "argocd"
+const hookTransformFlux = "flux"
+
+const hookWeightAnnotation = "helm.sh/hook-weight"
+const hookDeletePolicyAnnotation = "helm.sh/hook-delete-policy"
+
+const argoCDHookAnnotation = "argocd.argoproj.io/hook"
+const argoCDSyncWaveAnnotation = "argocd.argoproj.io/sync-wave"
+const argoCDHookDeletePolicyAnnotation = "argocd.argoproj.io/hook-delete-policy"
+
+// Subdirectories of hooks/ applied by Flux before and after the release
+const fluxPhasePre = "pre"
+const fluxPhasePost = "post"
+
+var hookTransformFlag string
+
+// Argo CD hooks of the helm hook types which have one
+var argoCDHooks = map[string]string{
+	"pre-install":  "PreSync",
+	"pre-upgrade":  "PreSync",
+	"post-install": "PostSync",
+	"post-upgrade": "PostSync",
+	"post-delete":  "PostDelete",
+}
+
+var argoCDHookDeletePolicies = map[string]string{
+	"before-hook-creation": "BeforeHookCreation",
+	"hook-succeeded":       "HookSucceeded",
+	"hook-failed":          "HookFailed",
+}
+
+// Flux phases of the helm hook types which have one
+var fluxPhases = map[string]string{
+	"pre-install":  fluxPhasePre,
+	"pre-upgrade":  fluxPhasePre,
+	"post-install": fluxPhasePost,
+	"post-upgrade": fluxPhasePost,
+}
+
+func validateHookTransform(transform string) {
+	switch transform {
+	case "", hookTransformNone, hookTransformArgoCD, hookTransformFlux:
+		return
+	}
+	fmt.Printf("ERROR! Unknown hook transform \"%v\", must be \"%v\", \"%v\" or \"%v\"!\n", transform, hookTransformNone, hookTransformArgoCD, hookTransformFlux)
+	exit(1)
+}
+
+// Rewrite the hooks left after the hook policies for a GitOps tool:
+// argocd replaces helm hook annotations by Argo CD ones,
+// flux moves pre and post hooks to their own directories
+func transformHooks(manifests []*outputManifestStruct, release *releaseStruct, transform string) {
+	for _, manifest := range manifests {
+		if len(manifest.Hooks) == 0 {
+			continue
+		}
+
+		switch transform {
+		case hookTransformArgoCD:
+			toArgoCDHook(manifest)
+		case hookTransformFlux:
+			phase := fluxPhase(manifest.Hooks)
+			if phase == "" {
+				printDebug("Hook %v/%v (%v) has no Flux phase, keeping it as is\n", manifest.Kind, manifest.Name, strings.Join(manifest.Hooks, ","))
+				continue
+			}
+			manifest.OutputDir = path.Join(release.OutputDir, hooksDir, phase)
+		}
+	}
+}
+
+// Replace helm.sh/hook, hook-weight and hook-delete-policy annotations
+// by their Argo CD counterparts. Hooks of types Argo CD does not know are left alone
+func toArgoCDHook(manifest *outputManifestStruct) {
+	var hooks []string
+	for _, hookType := range manifest.Hooks {
+		hook, found := argoCDHooks[hookType]
+		if !found {
+			printDebug("Hook %v/%v: %v hooks have no Argo CD equivalent\n", manifest.Kind, manifest.Name, hookType)
+			continue
+		}
+		if !containsString(hooks, hook) {
+			hooks = append(hooks, hook)
+		}
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	annotations := mappingValue(mappingValue(manifest.Node.Content[0], "metadata"), "annotations")
+
+	// Keys are added to the top, so they end up as hook, sync-wave, hook-delete-policy
+	if deletePolicy := mappingValue(annotations, hookDeletePolicyAnnotation); deletePolicy != nil {
+		var policies []string
+		for _, policy := range strings.Split(deletePolicy.Value, ",") {
+			policy = strings.TrimSpace(policy)
+			if argoCDPolicy, found := argoCDHookDeletePolicies[policy]; found {
+				policies = append(policies, argoCDPolicy)
+			} else if policy != "" {
+				printDebug("Hook %v/%v: delete policy %v has no Argo CD equivalent\n", manifest.Kind, manifest.Name, policy)
+			}
+		}
+		if len(policies) > 0 {
+			setMappingValue(annotations, argoCDHookDeletePolicyAnnotation, strings.Join(policies, ","))
+		}
+	}
+	if weight := mappingValue(annotations, hookWeightAnnotation); weight != nil {
+		setMappingValue(annotations, argoCDSyncWaveAnnotation, weight.Value)
+	}
+	setMappingValue(annotations, argoCDHookAnnotation, strings.Join(hooks, ","))
+
+	for _, key := range []string{hookAnnotation, hookWeightAnnotation, hookDeletePolicyAnnotation} {
+		removeMappingKey(annotations, key)
+	}
+
+	printDebug("Hook %v/%v: Argo CD %v\n", manifest.Kind, manifest.Name, strings.Join(hooks, ","))
+	manifest.Content = append([]byte("---\n"), marshalYaml(manifest.Node)...)
+}
+
+// Phase of a hook, the first one if it has several
+func fluxPhase(hookTypes []string) string {
+	for _, hookType := range hookTypes {
+		if phase, found := fluxPhases[hookType]; found {
+			return phase
+		}
+	}
+	return ""
+}
+
+// Value of a metadata annotation of a manifest, empty if absent
+func manifestAnnotation(manifest *outputManifestStruct, key string) string {
+	if manifest.Node == nil || len(manifest.Node.Content) == 0 {
+		return ""
+	}
+	annotation := mappingValue(mappingValue(mappingValue(manifest.Node.Content[0], "metadata"), "annotations"), key)
+	if annotation == nil {
+		return ""
+	}
+	return annotation.Value
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/releaseutil"
+)
+
+const kustomizationFilename = "kustomization.yaml"
+
+type kustomizationOptionsStruct struct {
+	Enabled      bool
+	Namespace    string
+	CommonLabels labelsValue
+	Nested       bool // list subdirectories as resources of the parent kustomization
+}
+
+type KustomizationStruct struct {
+	APIVersion   string            `yaml:"apiVersion"`
+	Kind         string            `yaml:"kind"`
+	Namespace    string            `yaml:"namespace,omitempty"`
+	CommonLabels map[string]string `yaml:"commonLabels,omitempty"`
+	Resources    []string          `yaml:"resources"`
+}
+
+// A resource entry of a kustomization: a manifest file or a subdirectory
+type kustomizationResourceStruct struct {
+	Name string
+	Rank int
+}
+
+// Generate kustomization.yaml for every directory with generated manifests.
+// Resources are ordered by kind, Namespaces and CRDs first
+func generateKustomizations(manifests []*outputManifestStruct, options *kustomizationOptionsStruct) []*outputManifestStruct {
+	resources := map[string][]kustomizationResourceStruct{}
+	subdirRanks := map[string]int{}
+
+	for _, manifest := range manifests {
+		dir := path.Dir(manifest.Filename)
+		if path.Base(manifest.Filename) == kustomizationFilename {
+			fmt.Printf("ERROR! Manifest %v/%v would overwrite the generated %v!\n", manifest.Kind, manifest.Name, manifest.Filename)
+			exit(1)
+		}
+
+		rank := kindRank(manifest.Kind)
+		resources[dir] = append(resources[dir], kustomizationResourceStruct{Name: path.Base(manifest.Filename), Rank: rank})
+
+		// Every parent up to the output directory gets the subdirectory as a resource.
+		// Hooks in their own subdirectory are not applied along with the rest
+		topDir := path.Clean(manifest.OutputDir)
+		for options.Nested && dir != topDir && strings.HasPrefix(dir, topDir+"/") {
+			if subdirRank, found := subdirRanks[dir]; !found || rank < subdirRank {
+				subdirRanks[dir] = rank
+			}
+			dir = path.Dir(dir)
+		}
+	}
+
+	for subdir, rank := range subdirRanks {
+		parent := path.Dir(subdir)
+		resources[parent] = append(resources[parent], kustomizationResourceStruct{Name: path.Base(subdir), Rank: rank})
+	}
+
+	dirs := make([]string, 0, len(resources))
+	for dir := range resources {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var kustomizations []*outputManifestStruct
+	for _, dir := range dirs {
+		dirResources := resources[dir]
+		sort.SliceStable(dirResources, func(i, j int) bool {
+			if dirResources[i].Rank != dirResources[j].Rank {
+				return dirResources[i].Rank < dirResources[j].Rank
+			}
+			return dirResources[i].Name < dirResources[j].Name
+		})
+
+		kustomization := KustomizationStruct{
+			APIVersion:   "kustomize.config.k8s.io/v1beta1",
+			Kind:         "Kustomization",
+			Namespace:    options.Namespace,
+			CommonLabels: options.CommonLabels,
+		}
+		for _, resource := range dirResources {
+			kustomization.Resources = append(kustomization.Resources, resource.Name)
+		}
+
+		kustomizations = append(kustomizations, &outputManifestStruct{
+			Kind:     kustomization.Kind,
+			Filename: dir + "/" + kustomizationFilename,
+			Content:  marshalYaml(&kustomization),
+		})
+	}
+
+	return kustomizations
+}
+
+// Position of a kind in the install order. Namespaces and CRDs go first,
+// then the helm install order, then all other kinds
+func kindRank(kind string) int {
+	switch kind {
+	case "Namespace":
+		return 0
+	case "CustomResourceDefinition":
+		return 1
+	}
+
+	for i, orderedKind := range releaseutil.InstallOrder {
+		if orderedKind == kind {
+			return i + 2
+		}
+	}
+	return len(releaseutil.InstallOrder) + 2
+}
+
+// labelsValue is a flag.Value for "key=value,key=value" lists
+type labelsValue map[string]string
+
+func (l *labelsValue) String() string {
+	var labels []string
+	for key, value := range *l {
+		labels = append(labels, key+"="+value)
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
+
+func (l *labelsValue) Set(text string) error {
+	if *l == nil {
+		*l = labelsValue{}
+	}
+	for _, label := range strings.Split(text, ",") {
+		key, value, found := strings.Cut(label, "=")
+		if !found || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("label \"%v\" must look like key=value", label)
+		}
+		(*l)[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return nil
+}
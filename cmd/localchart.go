@@ -0,0 +1,305 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/provenance"
+)
+
+type ChartMetadataStruct struct {
+	Name         string                  `yaml:"name"`
+	Version      string                  `yaml:"version"`
+	Dependencies []ChartDependencyStruct `yaml:"dependencies"`
+}
+
+type ChartDependencyStruct struct {
+	Name string `yaml:"name"`
+}
+
+func isChartArchive(chartPath string) bool {
+	return strings.HasSuffix(chartPath, ".tgz") || strings.HasSuffix(chartPath, ".tar.gz")
+}
+
+// Read the chart name from Chart.yaml of a local chart directory or archive
+func localChartName(chartPath string) string {
+	var chartYaml []byte
+	var err error
+
+	if isChartArchive(chartPath) {
+		chartYaml = readChartArchiveFile(chartPath, "Chart.yaml")
+	} else {
+		chartYaml, err = os.ReadFile(chartPath + "/Chart.yaml")
+		if err != nil {
+			fmt.Printf("ERROR! %v is not a helm chart: %v\n", chartPath, err)
+			exit(1)
+		}
+	}
+
+	var metadata ChartMetadataStruct
+	err = yaml.Unmarshal(chartYaml, &metadata)
+	checkErr(err)
+
+	if metadata.Name == "" {
+		fmt.Printf("ERROR! Chart.yaml in %v has no name!\n", chartPath)
+		exit(1)
+	}
+
+	return metadata.Name
+}
+
+func readChartMetadata(chartDir string) ChartMetadataStruct {
+	chartYaml, err := os.ReadFile(chartDir + "/Chart.yaml")
+	checkErr(err)
+
+	var metadata ChartMetadataStruct
+	err = yaml.Unmarshal(chartYaml, &metadata)
+	checkErr(err)
+
+	return metadata
+}
+
+func chartHasDependencies(chartDir string) bool {
+	if !fileIsAbsent(chartDir + "/Chart.lock") {
+		return true
+	}
+
+	return len(readChartMetadata(chartDir).Dependencies) > 0
+}
+
+// Find the archive "helm pull" saved to dir
+func pulledChartArchive(dir string) string {
+	archives, err := filepath.Glob(filepath.Join(dir, "*.tgz"))
+	checkErr(err)
+	if len(archives) != 1 {
+		fmt.Printf("ERROR! Expected one chart archive in %v, found %v!\n", dir, len(archives))
+		exit(1)
+	}
+	return archives[0]
+}
+
+// Copy a local chart directory or unpack a chart archive to destDir,
+// so the user's chart is never modified by "helm dependency build"
+func copyLocalChart(chartPath, destDir string) {
+	if isChartArchive(chartPath) {
+		walkChartArchive(chartPath, func(name string, header *tar.Header, reader io.Reader) bool {
+			target := filepath.Join(destDir, name)
+
+			switch header.Typeflag {
+			case tar.TypeDir:
+				err := os.MkdirAll(target, 0755)
+				checkErr(err)
+			case tar.TypeReg:
+				err := os.MkdirAll(filepath.Dir(target), 0755)
+				checkErr(err)
+				data, err := io.ReadAll(reader)
+				checkErr(err)
+				err = os.WriteFile(target, data, 0644)
+				checkErr(err)
+			}
+			return true
+		})
+		return
+	}
+
+	err := filepath.WalkDir(chartPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(chartPath, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destDir, relPath)
+
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+	if err != nil {
+		fmt.Printf("ERROR! Cannot copy chart %v: %v\n", chartPath, err)
+		exit(1)
+	}
+}
+
+// Point relative "file://" dependencies of a chart copied from chartPath to
+// chartDir back to the original location: "helm dependency build" would look
+// for them next to the copy. The digest in Chart.lock covers the repositories,
+// so it is recomputed, unless the lock was out of sync with Chart.yaml already
+func absoluteFileDependencies(chartDir, chartPath string) {
+	// Dependencies of a packaged chart are in its charts/ directory
+	if isChartArchive(chartPath) {
+		return
+	}
+	originalDir, err := filepath.Abs(chartPath)
+	checkErr(err)
+
+	lockFilename := chartDir + "/Chart.lock"
+	hasLock := !fileIsAbsent(lockFilename)
+	lockInSync := false
+	if hasLock {
+		digest, lockedDigest := chartLockDigests(chartDir)
+		lockInSync = digest != "" && digest == lockedDigest
+	}
+
+	if !editYamlFile(chartDir+"/Chart.yaml", func(root *yaml.Node) bool { return absoluteFileRepositories(root, originalDir) }) || !hasLock {
+		return
+	}
+	editYamlFile(lockFilename, func(root *yaml.Node) bool { return absoluteFileRepositories(root, originalDir) })
+
+	if lockInSync {
+		digest, _ := chartLockDigests(chartDir)
+		editYamlFile(lockFilename, func(root *yaml.Node) bool {
+			setMappingValue(root, "digest", digest)
+			return true
+		})
+	}
+}
+
+func absoluteFileRepositories(root *yaml.Node, originalDir string) bool {
+	dependencies := mappingValue(root, "dependencies")
+	if dependencies == nil {
+		return false
+	}
+
+	changed := false
+	for _, dependency := range dependencies.Content {
+		repository := mappingValue(dependency, "repository")
+		if repository == nil || !strings.HasPrefix(repository.Value, "file://") {
+			continue
+		}
+		dependencyPath := strings.TrimPrefix(repository.Value, "file://")
+		if filepath.IsAbs(dependencyPath) {
+			continue
+		}
+
+		printDebug("Resolving dependency %v relative to %v\n", repository.Value, originalDir)
+		repository.Value = "file://" + filepath.Join(originalDir, dependencyPath)
+		changed = true
+	}
+	return changed
+}
+
+// The digest of Chart.yaml and Chart.lock, computed the way helm does,
+// and the one recorded in Chart.lock. Both are empty if the chart cannot be loaded
+func chartLockDigests(chartDir string) (string, string) {
+	loadedChart, err := loader.LoadDir(chartDir)
+	if err != nil || loadedChart.Lock == nil {
+		printDebug("WARNING! Cannot load chart %v: %v\n", chartDir, err)
+		return "", ""
+	}
+
+	data, err := json.Marshal([2][]*chart.Dependency{loadedChart.Metadata.Dependencies, loadedChart.Lock.Dependencies})
+	checkErr(err)
+	digest, err := provenance.Digest(bytes.NewReader(data))
+	checkErr(err)
+
+	return "sha256:" + digest, loadedChart.Lock.Digest
+}
+
+// Change a YAML file in place, keeping its comments. The file is written
+// only if edit returns true
+func editYamlFile(filename string, edit func(root *yaml.Node) bool) bool {
+	content, err := os.ReadFile(filename)
+	checkErr(err)
+
+	var document yaml.Node
+	err = yaml.Unmarshal(content, &document)
+	checkErr(err)
+	if len(document.Content) == 0 || !edit(document.Content[0]) {
+		return false
+	}
+
+	var output bytes.Buffer
+	encoder := yaml.NewEncoder(&output)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&document)
+	checkErr(err)
+	encoder.Close()
+
+	err = os.WriteFile(filename, output.Bytes(), 0644)
+	checkErr(err)
+	return true
+}
+
+func readChartArchiveFile(archivePath, fileName string) []byte {
+	var data []byte
+
+	walkChartArchive(archivePath, func(name string, header *tar.Header, reader io.Reader) bool {
+		if name != fileName {
+			return true
+		}
+
+		var err error
+		data, err = io.ReadAll(reader)
+		checkErr(err)
+		return false
+	})
+
+	if data == nil {
+		fmt.Printf("ERROR! %v not found in %v!\n", fileName, archivePath)
+		exit(1)
+	}
+
+	return data
+}
+
+// Call fn for every entry of a chart archive. Entry names are relative
+// to the chart root, i.e. without the leading "<chart>/" directory.
+// Iteration stops when fn returns false
+func walkChartArchive(archivePath string, fn func(name string, header *tar.Header, reader io.Reader) bool) {
+	file, err := os.Open(archivePath)
+	checkErr(err)
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		fmt.Printf("ERROR! Cannot read chart archive %v: %v\n", archivePath, err)
+		exit(1)
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Printf("ERROR! Cannot read chart archive %v: %v\n", archivePath, err)
+			exit(1)
+		}
+
+		name := filepath.ToSlash(filepath.Clean(header.Name))
+		_, name, found := strings.Cut(name, "/")
+		if !found || name == "" {
+			continue
+		}
+		if name == ".." || strings.HasPrefix(name, "../") {
+			fmt.Printf("ERROR! Chart archive %v contains an unsafe path %v!\n", archivePath, header.Name)
+			exit(1)
+		}
+
+		if !fn(name, header, tarReader) {
+			break
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/getter"
+)
+
+// A chart depending on a sibling chart of a monorepo, by a relative path
+func TestRelativeFileDependencies(t *testing.T) {
+	t.Setenv("HELM_REPOSITORY_CONFIG", filepath.Join(t.TempDir(), "repositories.yaml"))
+
+	tests := []struct {
+		name   string
+		locked bool
+	}{
+		{name: "without Chart.lock"},
+		{name: "with Chart.lock", locked: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFile(t, filepath.Join(dir, "dep", "Chart.yaml"), "apiVersion: v2\nname: dep\nversion: 0.1.0\n")
+			writeTestFile(t, filepath.Join(dir, "dep", "templates", "_helpers.tpl"), `{{ define "dep.greeting" }}hello from dep{{ end }}`)
+
+			chartPath := filepath.Join(dir, "app")
+			writeTestFile(t, filepath.Join(chartPath, "Chart.yaml"), testChartYaml+"dependencies:\n  # sibling chart\n  - name: dep\n    version: 0.1.0\n    repository: file://../dep\n")
+			writeTestFile(t, filepath.Join(chartPath, "templates", "configmap.yaml"), testChartTemplate+"data:\n  greeting: {{ include \"dep.greeting\" . }}\n")
+
+			if test.locked {
+				settings := cli.New()
+				manager := &downloader.Manager{
+					Out:              debugWriter{},
+					ChartPath:        chartPath,
+					Getters:          getter.All(settings),
+					RepositoryConfig: settings.RepositoryConfig,
+					RepositoryCache:  t.TempDir(),
+				}
+				if err := manager.Update(); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.RemoveAll(filepath.Join(chartPath, "charts")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			release := releaseStruct{Name: "web", Chart: "demo", ChartPath: chartPath, Namespace: "ns", TmpDir: t.TempDir()}
+			renderedFiles := (&sdkRenderer{}).Render(&release)
+
+			want := []renderedFileStruct{{Path: "templates/configmap.yaml", Content: []byte("---\n# Source: demo/templates/configmap.yaml\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\ndata:\n  greeting: hello from dep\n")}}
+			if !reflect.DeepEqual(renderedFiles, want) {
+				t.Errorf("rendered files:\n got %q\nwant %q", renderedFiles, want)
+			}
+
+			// Only the copy is changed, and its comments are kept
+			copied, err := os.ReadFile(filepath.Join(release.TmpDir, "demo", "Chart.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(copied), "repository: file://"+filepath.Join(dir, "dep")+"\n") || !strings.Contains(string(copied), "# sibling chart") {
+				t.Errorf("Chart.yaml of the copy:\n%s", copied)
+			}
+			original, err := os.ReadFile(filepath.Join(chartPath, "Chart.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(original), "repository: file://../dep\n") {
+				t.Errorf("original Chart.yaml is changed:\n%s", original)
+			}
+		})
+	}
+}
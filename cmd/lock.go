@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	runtimeDebug "runtime/debug"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultLockFilename = "helm-splitter.lock"
+
+// Version of the tool, set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// The lock file records what every release was rendered from,
+// so a later run with --locked renders exactly the same chart
+type LockStruct struct {
+	Releases []LockReleaseStruct `yaml:"releases"`
+}
+
+type LockReleaseStruct struct {
+	Name        string             `yaml:"name"`
+	Chart       string             `yaml:"chart"`
+	Repository  string             `yaml:"repository,omitempty"` // repository URL or oci:// reference
+	ChartPath   string             `yaml:"chartPath,omitempty"`
+	Version     string             `yaml:"version"`
+	Digest      string             `yaml:"digest,omitempty"` // sha256 of the chart archive, empty for chart directories
+	Values      []LockValuesStruct `yaml:"values,omitempty"`
+	Set         []LockSetStruct    `yaml:"set,omitempty"`
+	KubeVersion string             `yaml:"kubeVersion,omitempty"`
+	APIVersions []string           `yaml:"apiVersions,omitempty"`
+	ToolVersion string             `yaml:"toolVersion"`
+}
+
+type LockValuesStruct struct {
+	File   string `yaml:"file"`
+	Digest string `yaml:"digest"`
+}
+
+// A --set* value, files of --set-file are recorded with their digest
+type LockSetStruct struct {
+	Flag   string `yaml:"flag"`
+	Value  string `yaml:"value"`
+	Digest string `yaml:"digest,omitempty"`
+}
+
+func toolVersion() string {
+	if version != "dev" {
+		return version
+	}
+	if buildInfo, ok := runtimeDebug.ReadBuildInfo(); ok && buildInfo.Main.Version != "" && buildInfo.Main.Version != "(devel)" {
+		return buildInfo.Main.Version
+	}
+	return version
+}
+
+func readLock(lockFile string) LockStruct {
+	var lock LockStruct
+
+	lockByte, err := os.ReadFile(lockFile)
+	if os.IsNotExist(err) {
+		printDebug("No lock file %v found\n", lockFile)
+		return lock
+	}
+	checkErr(err)
+
+	err = yaml.Unmarshal(lockByte, &lock)
+	if err != nil {
+		fmt.Printf("ERROR! Cannot parse %v: %v\n", lockFile, err)
+		exit(1)
+	}
+
+	return lock
+}
+
+// Update the lock entries of the rendered releases. A project owns the
+// whole lock file, so entries of releases no longer in it are dropped
+func writeLock(lockFile string, releases []*releaseStruct, isProject bool) {
+	entries := map[string]LockReleaseStruct{}
+	if !isProject {
+		for _, entry := range readLock(lockFile).Releases {
+			entries[entry.Name] = entry
+		}
+	}
+	for _, release := range releases {
+		entries[release.Name] = lockEntry(lockFile, release)
+	}
+
+	var lock LockStruct
+	for _, entry := range entries {
+		lock.Releases = append(lock.Releases, entry)
+	}
+	sort.Slice(lock.Releases, func(i, j int) bool {
+		return lock.Releases[i].Name < lock.Releases[j].Name
+	})
+
+	printDebug("Writing lock file %v\n", lockFile)
+	content := append([]byte("# Generated by helm-splitter. Do not edit\n"), marshalYaml(&lock)...)
+	err := os.WriteFile(lockFile, content, 0644)
+	checkErr(err)
+}
+
+func lockEntry(lockFile string, release *releaseStruct) LockReleaseStruct {
+	entry := LockReleaseStruct{
+		Name:        release.Name,
+		Chart:       release.Chart,
+		Repository:  lockRepository(release),
+		ChartPath:   lockPath(lockFile, release.ChartPath),
+		Version:     release.ResolvedVersion,
+		Digest:      release.ChartDigest,
+		KubeVersion: release.KubeVersion,
+		APIVersions: release.APIVersions,
+		ToolVersion: toolVersion(),
+	}
+
+	for _, valuesFile := range release.ValuesFiles {
+		entry.Values = append(entry.Values, LockValuesStruct{File: lockPath(lockFile, valuesFile), Digest: fileDigest(valuesFile)})
+	}
+
+	for _, setValue := range release.SetValues {
+		lockSet := LockSetStruct{Flag: setValue.Flag, Value: setValue.Value}
+		if setValue.Flag == setFileFlag {
+			key, filename, _ := strings.Cut(setValue.Value, "=")
+			lockSet.Value = key + "=" + lockPath(lockFile, filename)
+			lockSet.Digest = fileDigest(filename)
+		}
+		entry.Set = append(entry.Set, lockSet)
+	}
+
+	return entry
+}
+
+func lockRepository(release *releaseStruct) string {
+	if release.ChartRef != "" {
+		return release.ChartRef
+	}
+	return release.Repository
+}
+
+// Paths in the lock file are relative to it, if possible
+func lockPath(lockFile, filename string) string {
+	if filename == "" {
+		return ""
+	}
+	lockDir, err := filepath.Abs(filepath.Dir(lockFile))
+	checkErr(err)
+	absPath, err := filepath.Abs(filename)
+	checkErr(err)
+	if relPath, err := filepath.Rel(lockDir, absPath); err == nil {
+		return filepath.ToSlash(relPath)
+	}
+	return filename
+}
+
+// With --locked every release must be in the lock file with the same chart.
+// Releases without --version get the locked version
+func pinLockedVersions(lockFile string, releases []*releaseStruct) {
+	entries := lockEntries(lockFile)
+
+	for _, release := range releases {
+		entry, found := entries[release.Name]
+		if !found {
+			fmt.Printf("ERROR! Release %v is not in %v! Rerun without --locked to add it\n", release.Name, lockFile)
+			exit(1)
+		}
+
+		if entry.Chart != release.Chart || entry.Repository != lockRepository(release) || entry.ChartPath != lockPath(lockFile, release.ChartPath) {
+			fmt.Printf("ERROR! Chart of release %v differs from %v! Rerun without --locked to update it\n", release.Name, lockFile)
+			exit(1)
+		}
+
+		if release.Version == "" {
+			release.Version = entry.Version
+			printDebug("Using locked version %v of release %v\n", entry.Version, release.Name)
+		}
+	}
+}
+
+// With --locked the rendered charts must be the locked ones
+func verifyLockedCharts(lockFile string, releases []*releaseStruct) {
+	entries := lockEntries(lockFile)
+
+	for _, release := range releases {
+		entry := entries[release.Name]
+
+		if release.ResolvedVersion != entry.Version {
+			fmt.Printf("ERROR! Release %v resolved to chart version %v, but %v is locked! Rerun without --locked to update %v\n", release.Name, release.ResolvedVersion, entry.Version, lockFile)
+			exit(1)
+		}
+		if release.ChartDigest != entry.Digest {
+			fmt.Printf("ERROR! Chart of release %v has digest %v, but %v is locked! Rerun without --locked to update %v\n", release.Name, release.ChartDigest, entry.Digest, lockFile)
+			exit(1)
+		}
+	}
+}
+
+func lockEntries(lockFile string) map[string]LockReleaseStruct {
+	if fileIsAbsent(lockFile) {
+		fmt.Printf("ERROR! Lock file %v does not exist! Rerun without --locked to create it\n", lockFile)
+		exit(1)
+	}
+
+	entries := map[string]LockReleaseStruct{}
+	for _, entry := range readLock(lockFile).Releases {
+		entries[entry.Name] = entry
+	}
+	return entries
+}
+
+func fileDigest(filename string) string {
+	file, err := os.Open(filename)
+	if err != nil {
+		fmt.Printf("ERROR! Cannot read %v: %v\n", filename, err)
+		exit(1)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	checkErr(err)
+
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil))
+}
@@ -3,95 +3,395 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/fs"
 	"os"
-	"os/exec"
 	"os/user"
-	"regexp"
+	"path"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
-const tmpDir = "helm_splitter_tmp"
 const etcConfigPath = "/etc/helm-splitter/config.yaml"
 const homeConfigName = ".helm-splitter.yaml"
 
-var overwrite, debug bool
+const commandRender = "render"
+const commandDiff = "diff"
+const commandCheck = "check"
+const commandOutdated = "outdated"
+const commandUpgrade = "upgrade"
+
+// Exit code of --dry-run when the output directory would change
+const exitCodeChanges = 2
+
+// Temporary directory of the run, every release gets a subdirectory
+var tmpDir string
+
+var parallelism int
+var overwrite, keepLists, saveShortcuts, prune, pruneDryRun, dryRun, checkOnly, locked, jsonOutput, debug bool
 
 type configStruct struct {
-	FilePath  string            `yaml:"filepath,omitempty"`
-	Shortcuts map[string]string `yaml:"shortcuts"`
+	FilePath          string                   `yaml:"-"`
+	UnknownKinds      string                   `yaml:"unknownKinds,omitempty"`
+	CollisionStrategy string                   `yaml:"collisionStrategy,omitempty"`
+	FilenameTemplate  string                   `yaml:"filenameTemplate,omitempty"`
+	Clusters          map[string]ClusterStruct `yaml:"clusters,omitempty"`
+	Hooks             map[string]string        `yaml:"hooks,omitempty"` // policy of every hook type
+	HookTransform     string                   `yaml:"hookTransform,omitempty"`
+	GitOps            GitOpsStruct             `yaml:"gitops,omitempty"`
+	Shortcuts         map[string]string        `yaml:"shortcuts"`
+}
+
+// Everything needed to render a single helm release
+type releaseStruct struct {
+	Name   string // helm release name, the chart name by default
+	TmpDir string // where the chart is pulled and rendered, inside tmpDir
+
+	// The chart actually rendered, recorded in the lock file
+	ResolvedVersion string
+	ChartDigest     string
+	Namespace       string
+	Repository      string
+	Chart           string // chart name, also used for the rendered directory
+	ChartRef        string // oci:// reference, if the chart is stored in an OCI registry
+	ChartPath       string // local chart directory or archive
+	Version         string
+	ValuesFiles     []string
+	SetValues       []setValueStruct
+	Cluster         string // profile in the "clusters" section of the config
+	KubeVersion     string
+	APIVersions     apiVersionsValue
+	OutputDir       string
+	SkipCRDs        bool
+
+	Kustomization kustomizationOptionsStruct
 }
 
 type ManifestStruct struct {
-	Kind     string         `yaml:"kind"`
-	Metadata MetadataStruct `yaml:"metadata"`
+	APIVersion string         `yaml:"apiVersion"`
+	Kind       string         `yaml:"kind"`
+	Metadata   MetadataStruct `yaml:"metadata"`
 }
 
 type MetadataStruct struct {
-	Name string `yaml:"name"`
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// A manifest to be written to the output directory
+type outputManifestStruct struct {
+	Release    string
+	Kind       string
+	APIVersion string
+	Name       string
+	Namespace  string
+	Shortcut   string
+	Source     string // rendered file the manifest comes from, relative to the chart root
+	OutputDir  string
+	Subchart   string   // subdirectory of templates/ or crds/ the manifest comes from
+	Suffix     string   // added to the name to tell apart manifests with the same filename
+	Hooks      []string // helm hook types, empty for regular manifests
+	Filename   string
+	Content    []byte
+	Node       *yaml.Node
 }
 
 func main() {
+	command, args := parseCommand(os.Args[1:])
 
 	// Read and validate input params
-	var namespace, helmRepo, helmChart, helmChartVersion, customValues, outputDir, includeCRDsFlag, customConfigFile string
-	var skipCRDs bool
-	readInputParams(&namespace, &helmRepo, &helmChart, &helmChartVersion, &customValues, &outputDir, &customConfigFile, &skipCRDs)
-	validateInputParams(&namespace, &helmChart, &helmRepo, &helmChartVersion, &customValues, &outputDir, &includeCRDsFlag, skipCRDs)
+	var release releaseStruct
+	var customConfigFile, projectFile, lockFile, targetVersion, helmBinary, rendererName, unknownKinds, collisionStrategy, filenameTemplate string
+	readInputParams(command, args, &release, &customConfigFile, &projectFile, &lockFile, &targetVersion, &helmBinary, &rendererName, &unknownKinds, &collisionStrategy, &filenameTemplate)
+
+	var releases []*releaseStruct
+	if projectFile != "" {
+		if release.Name != "" || release.Chart != "" || release.ChartPath != "" || release.Repository != "" || release.Version != "" ||
+			release.Namespace != "" || len(release.ValuesFiles) > 0 || len(release.SetValues) > 0 || release.OutputDir != "" {
+			fmt.Println("ERROR! \"--project\" cannot be used with \"--release-name\", \"--chart\", \"--chart-path\", \"--repository\", \"--version\", \"--namespace\", \"--values\", \"--set*\" or \"--output-dir\"!")
+			exit(1)
+		}
+
+		project := readProject(projectFile)
+		if customConfigFile == "" {
+			customConfigFile = project.Config
+		}
+		releases = projectReleases(projectFile, &project, &release, rendererName)
+	} else {
+		// outdated does not render anything, so any namespace will do
+		if command == commandOutdated && release.Namespace == "" {
+			release.Namespace = "default"
+		}
+		validateInputParams(&release, rendererName)
+		releases = []*releaseStruct{&release}
+	}
+
+	if lockFile == "" {
+		lockFile = defaultLockFilename
+		if projectFile != "" {
+			lockFile = projectPath(projectFile, defaultLockFilename)
+		}
+	}
+
+	if command == commandOutdated {
+		reportOutdated(releases, lockFile)
+		exit(0)
+	}
 
 	config := parseConfig(customConfigFile)
+	if unknownKinds != "" {
+		config.UnknownKinds = unknownKinds
+	}
+	validateUnknownKinds(config.UnknownKinds)
+	if collisionStrategy != "" {
+		config.CollisionStrategy = collisionStrategy
+	}
+	validateCollisionStrategy(config.CollisionStrategy)
+	if filenameTemplate != "" {
+		config.FilenameTemplate = filenameTemplate
+	}
+	config.Hooks = hookPolicies(&config, hookFlags)
+	if hookTransformFlag != "" {
+		config.HookTransform = hookTransformFlag
+	}
+	validateHookTransform(config.HookTransform)
+	config.GitOps = gitOpsSettings(&config, &gitOpsFlags)
+	for _, release := range releases {
+		applyClusterProfile(release, &config)
+	}
+
+	if command == commandUpgrade {
+		if projectFile == "" && len(flag.Args()) > 0 {
+			fmt.Println("ERROR! Releases to upgrade can be selected only with \"--project\"!")
+			exit(1)
+		}
+		if projectFile != "" && targetVersion != "" && len(flag.Args()) != 1 {
+			fmt.Println("ERROR! \"--to\" can be used with \"--project\" only when exactly one release is selected!")
+			exit(1)
+		}
+		upgradeReleases(releases, flag.Args(), targetVersion, lockFile, projectFile, newRenderer(rendererName, helmBinary), &config)
+		if !dryRun {
+			fmt.Println("Done!")
+		}
+		exit(0)
+	}
+
+	if locked {
+		pinLockedVersions(lockFile, releases)
+	}
+
+	createTmpDirs(releases)
 
-	execHelmCommands(helmChart, helmRepo, helmChartVersion, customValues, includeCRDsFlag, namespace)
+	// Charts are pulled and rendered concurrently, the rest is done
+	// one release after another, so the output is always the same
+	renderedReleases := renderReleases(newRenderer(rendererName, helmBinary), releases, parallelism)
 
-	// Rename all rendered yamls
-	processRenderedDir(tmpDir+"/rendered/"+helmChart+"/templates", &config, outputDir)
-	processRenderedDir(tmpDir+"/rendered/"+helmChart+"/crds", &config, outputDir)
+	if locked {
+		verifyLockedCharts(lockFile, releases)
+	}
 
-	fmt.Println("Done!")
-	exit(0)
+	exitCode := 0
+	var checkResults []*CheckResultStruct
+	for i, release := range releases {
+		if projectFile != "" && !jsonOutput {
+			fmt.Printf("Release %v\n", release.Name)
+		}
+
+		// Rename all rendered yamls
+		manifests := splitAndRename(renderedReleases[i], release, &config)
+
+		switch {
+		case checkOnly:
+			result := checkManifests(manifests, release.OutputDir)
+			if projectFile != "" {
+				result.Release = release.Name
+			}
+			checkResults = append(checkResults, result)
+			exitCode |= result.ExitCode
+		case dryRun:
+			if diffManifests(manifests, release.OutputDir) {
+				exitCode = exitCodeChanges
+			}
+		default:
+			writeManifests(manifests)
+			if prune || pruneDryRun {
+				pruneStaleFiles(manifests, release.OutputDir, pruneDryRun)
+			}
+		}
+	}
+
+	if checkOnly {
+		printCheckResults(checkResults, projectFile != "")
+		exit(exitCode)
+	}
+
+	// The diff ends with its summary
+	if !dryRun {
+		writeLock(lockFile, releases, projectFile != "")
+		fmt.Println("Done!")
+	}
+	exit(exitCode)
+}
+
+// Get the command from the first argument, "render" if there is none
+func parseCommand(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return commandRender, args
+	}
+
+	switch args[0] {
+	case commandRender, commandDiff, commandCheck, commandOutdated, commandUpgrade:
+		return args[0], args[1:]
+	}
+
+	fmt.Printf("ERROR! Unknown command \"%v\", must be \"%v\", \"%v\", \"%v\", \"%v\" or \"%v\"!\n", args[0], commandRender, commandDiff, commandCheck, commandOutdated, commandUpgrade)
+	exit(1)
+	return "", nil
 }
 
-func readInputParams(namespace, helmRepo, helmChart, helmChartVersion, customValues, outputDir, customConfigFile *string, skipCRDs *bool) {
-	flag.StringVar(namespace, "namespace", "", "target k8s namespace")
-	flag.StringVar(helmRepo, "repository", "", "helm repository")
-	flag.StringVar(helmChart, "chart", "", "helm chart name")
-	flag.StringVar(helmChartVersion, "version", "", "helm chart version, default: <latest>")
-	flag.StringVar(customValues, "custom-values-file", "", "file with custom values")
-	flag.StringVar(outputDir, "output-dir", "", "output directory")
-	flag.BoolVar(skipCRDs, "skip-crds", false, "do not generate CRDs, default: false")
+func readInputParams(command string, args []string, release *releaseStruct, customConfigFile, projectFile, lockFile, targetVersion, helmBinary, rendererName, unknownKinds, collisionStrategy, filenameTemplate *string) {
+	flag.StringVar(&release.Name, "release-name", "", "helm release name, default: <helm_chart_name>")
+	flag.StringVar(&release.Namespace, "namespace", "", "target k8s namespace")
+	flag.StringVar(&release.Repository, "repository", "", "helm repository")
+	flag.StringVar(&release.Chart, "chart", "", "helm chart name or oci:// reference")
+	flag.StringVar(&release.ChartPath, "chart-path", "", "local chart directory or .tgz archive, used instead of --chart and --repository")
+	flag.StringVar(&release.Version, "version", "", "helm chart version, default: <latest>")
+	addValuesFile := func(valuesFile string) error {
+		release.ValuesFiles = append(release.ValuesFiles, valuesFile)
+		return nil
+	}
+	flag.Func("values", "file with custom values, may be repeated", addValuesFile)
+	flag.Func("custom-values-file", "same as --values", addValuesFile)
+	for _, setFlagName := range []string{setFlag, setStringFlag, setFileFlag, setJSONFlag} {
+		flag.Func(setFlagName, "same as helm --"+setFlagName+", may be repeated", func(value string) error {
+			release.SetValues = append(release.SetValues, setValueStruct{Flag: setFlagName, Value: value})
+			return validateSetValue(setFlagName, value)
+		})
+	}
+	flag.StringVar(&release.OutputDir, "output-dir", "", "output directory")
+	flag.StringVar(&release.KubeVersion, "kube-version", "", "kubernetes version for .Capabilities.KubeVersion, e.g. 1.29, default: helm's default")
+	flag.Var(&release.APIVersions, "api-versions", "kubernetes API versions for .Capabilities.APIVersions, e.g. policy/v1/PodDisruptionBudget, may be repeated or comma-separated")
+	flag.StringVar(&release.Cluster, "cluster", "", "cluster profile from the \"clusters\" section of the config with the kube version and API versions")
+	flag.BoolVar(&release.SkipCRDs, "skip-crds", false, "do not generate CRDs, default: false")
 	flag.BoolVar(&overwrite, "overwrite", false, "overwrite existing output files, default: false")
+	flag.BoolVar(&dryRun, "dry-run", false, "do not write anything, print a diff against the output directory instead and exit with code 2 if there are changes, same as the \""+commandDiff+"\" command, default: false")
+	flag.BoolVar(&prune, "prune", false, "remove files generated by a previous run with --prune, which were not generated this time, default: false")
+	flag.BoolVar(&pruneDryRun, "prune-dry-run", false, "only list files --prune would remove, default: false")
+	flag.BoolVar(&keepLists, "keep-lists", false, "write List manifests as is, instead of splitting them into items, default: false")
+	flag.StringVar(unknownKinds, "unknown-kinds", "", "what to do with kinds without a shortcut: \""+unknownKindsError+"\", \""+unknownKindsAuto+"\" or \""+unknownKindsFullname+"\", default: "+unknownKindsError)
+	flag.BoolVar(&saveShortcuts, "save-shortcuts", false, "add shortcuts generated for unknown kinds to the config file, default: false")
+	flag.StringVar(filenameTemplate, "filename-template", "", "go template of output filenames, relative to the output directory, default: "+defaultFilenameTemplate)
+	flag.BoolVar(&release.Kustomization.Enabled, "kustomization", false, "generate kustomization.yaml in every output directory, default: false")
+	flag.StringVar(&release.Kustomization.Namespace, "kustomization-namespace", "", "namespace to set in generated kustomization.yaml")
+	flag.Var(&release.Kustomization.CommonLabels, "kustomization-labels", "commonLabels to set in generated kustomization.yaml, e.g. app=foo,team=bar")
+	flag.BoolVar(&release.Kustomization.Nested, "kustomization-nested", false, "list subdirectories as resources of the parent kustomization.yaml, default: false")
+	flag.Var(&hookFlags, "hooks", "what to do with helm hooks of every type: \""+hookPolicyDrop+"\", \""+hookPolicySubdir+"\" or \""+hookPolicyKeep+"\", e.g. test=drop,pre-install=subdir,default=keep, default: test="+hookPolicyDrop+",default="+hookPolicyKeep)
+	flag.StringVar(&hookTransformFlag, "hook-transform", "", "translate hooks for a GitOps tool: \""+hookTransformNone+"\", \""+hookTransformArgoCD+"\" or \""+hookTransformFlux+"\", default: "+hookTransformNone)
+	flag.StringVar(&gitOpsFlags.Generate, "gitops", "", "generate a GitOps resource for every output directory: \""+gitOpsNone+"\", \""+gitOpsArgoCD+"\" or \""+gitOpsFlux+"\", default: "+gitOpsNone)
+	flag.StringVar(&gitOpsFlags.Namespace, "gitops-namespace", "", "namespace of the generated Argo CD Applications or Flux Kustomizations, default: "+defaultArgoCDNamespace+" or "+defaultFluxNamespace)
+	flag.StringVar(&gitOpsFlags.PathPrefix, "gitops-path-prefix", "", "path of the current directory in the repository, prepended to the output directories")
+	flag.StringVar(&gitOpsFlags.TargetNamespace, "gitops-target-namespace", "", "namespace to deploy the releases to, default: <namespace> for Argo CD, none for Flux")
+	flag.StringVar(&gitOpsFlags.SyncPolicy, "gitops-sync-policy", "", "sync policy: \""+syncPolicyManual+"\", \""+syncPolicyAuto+"\" or \""+syncPolicyAutoPrune+"\", default: "+syncPolicyAutoPrune)
+	flag.StringVar(&gitOpsFlags.RepoURL, "gitops-repo-url", "", "repository URL of Argo CD Applications")
+	flag.StringVar(&gitOpsFlags.Revision, "gitops-revision", "", "target revision of Argo CD Applications, default: "+defaultArgoCDRevision)
+	flag.StringVar(&gitOpsFlags.Project, "gitops-project", "", "project of Argo CD Applications, default: "+defaultArgoCDProject)
+	flag.StringVar(&gitOpsFlags.Source, "flux-source", "", "Flux source with the output directories, <Kind>/<name>, default: "+defaultFluxSource)
+	flag.StringVar(collisionStrategy, "collision-strategy", "", "how to tell apart manifests with the same output filename: comma-separated list of \"namespace\", \"group\", \"hash\", default: none")
+	flag.BoolVar(&jsonOutput, "json", false, "print the result of the \""+commandCheck+"\" and \""+commandOutdated+"\" commands as JSON, default: false")
+	flag.StringVar(projectFile, "project", "", "project file with releases to render, used instead of the chart flags, e.g. "+defaultProjectFile)
+	flag.StringVar(lockFile, "lock-file", "", "lock file with resolved charts, default: "+defaultLockFilename+" next to the project file, or in the current directory")
+	flag.BoolVar(&locked, "locked", false, "render exactly the charts recorded in the lock file, fail if they differ, default: false")
+	flag.StringVar(targetVersion, "to", "", "chart version to upgrade to with the \""+commandUpgrade+"\" command, default: <latest>")
+	flag.BoolVar(&assumeYes, "yes", false, "apply upgrades without asking, default: false")
 	flag.StringVar(customConfigFile, "config", "", "path to config file")
+	flag.StringVar(rendererName, "renderer", rendererExec, "how to render the chart: \""+rendererExec+"\" runs the helm binary, \""+rendererSDK+"\" uses the embedded helm SDK")
+	flag.StringVar(helmBinary, "helm-binary", "helm", "helm binary to run, used only with --renderer="+rendererExec)
+	flag.IntVar(&parallelism, "parallelism", 1, "how many releases of a project to pull and render at once")
 	flag.BoolVar(&debug, "debug", false, "debug")
 
-	flag.Parse()
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [%v|%v|%v|%v] [flags]\n       %v %v [flags] [release...]\n", os.Args[0], commandRender, commandDiff, commandCheck, commandOutdated, os.Args[0], commandUpgrade)
+		flag.PrintDefaults()
+	}
+	flag.CommandLine.Parse(args)
+
+	// Parsing stops at the first argument which is not a flag, so a command
+	// given after flags would silently turn check or diff into a render
+	if command != commandUpgrade && flag.NArg() > 0 {
+		fmt.Printf("ERROR! Unexpected argument \"%v\": the command must be the first argument and only \"%v\" takes release names!\n", flag.Arg(0), commandUpgrade)
+		exit(1)
+	}
+
+	if parallelism < 1 {
+		fmt.Println("ERROR! \"--parallelism\" must be at least 1!")
+		exit(1)
+	}
+
+	if command == commandDiff {
+		dryRun = true
+	}
 
-	printDebug("Input values:\nNamespace: %v\nRepository: %v\nChart: %v\nVersion: %v\nCustom Values: %v\nSkip CRDs: %t\nOverwrte: %t\nConfig: %v\nDebug: %t\n", *namespace, *helmRepo, *helmChart, *helmChartVersion, *customValues, *skipCRDs, overwrite, *customConfigFile, debug)
+	// check never writes anything either
+	if command == commandCheck {
+		checkOnly = true
+		dryRun = true
+	}
+
+	printDebug("Input values:\nRelease Name: %v\nNamespace: %v\nRepository: %v\nChart: %v\nChart Path: %v\nVersion: %v\nValues Files: %v\nSet Values: %v\nCluster: %v\nKube Version: %v\nAPI Versions: %v\nSkip CRDs: %t\nOverwrte: %t\nDry Run: %t\nCheck: %t\nJSON: %t\nPrune: %t\nPrune Dry Run: %t\nKeep Lists: %t\nUnknown Kinds: %v\nSave Shortcuts: %t\nFilename Template: %v\nCollision Strategy: %v\nHooks: %v\nHook Transform: %v\nGitOps: %+v\nKustomization: %+v\nProject: %v\nLock File: %v\nLocked: %t\nTo: %v\nYes: %t\nConfig: %v\nRenderer: %v\nHelm Binary: %v\nParallelism: %v\nDebug: %t\n", release.Name, release.Namespace, release.Repository, release.Chart, release.ChartPath, release.Version, release.ValuesFiles, release.SetValues, release.Cluster, release.KubeVersion, release.APIVersions, release.SkipCRDs, overwrite, dryRun, checkOnly, jsonOutput, prune, pruneDryRun, keepLists, *unknownKinds, saveShortcuts, *filenameTemplate, *collisionStrategy, hookFlags.String(), hookTransformFlag, gitOpsFlags, release.Kustomization, *projectFile, *lockFile, locked, *targetVersion, assumeYes, *customConfigFile, *rendererName, *helmBinary, parallelism, debug)
 }
 
-func validateInputParams(namespace, helmChart, helmRepo, helmChartVersion, customValues, outputDir, includeCRDsFlag *string, skipCRDs bool) {
-	if *namespace == "" || *helmChart == "" || *helmRepo == "" {
-		fmt.Println("ERROR! Missing parameters. \"--namespace\", \"--repository\" and \"--chart\" MUST be specified!")
+func validateInputParams(release *releaseStruct, rendererName string) {
+	if rendererName != rendererExec && rendererName != rendererSDK {
+		fmt.Printf("ERROR! Unknown renderer \"%v\", must be \"%v\" or \"%v\"!\n", rendererName, rendererExec, rendererSDK)
 		exit(1)
 	}
 
-	if *helmChartVersion != "" {
-		*helmChartVersion = " --version " + *helmChartVersion
+	if release.ChartPath != "" {
+		if release.Namespace == "" {
+			fmt.Println("ERROR! Missing parameters. \"--namespace\" MUST be specified!")
+			exit(1)
+		}
+		if release.Chart != "" || release.Repository != "" || release.Version != "" {
+			fmt.Println("ERROR! \"--chart-path\" cannot be used with \"--chart\", \"--repository\" or \"--version\"!")
+			exit(1)
+		}
+		if fileIsAbsent(release.ChartPath) {
+			fmt.Printf("ERROR! Chart path %v does not exist!\n", release.ChartPath)
+			exit(1)
+		}
+		release.Chart = localChartName(release.ChartPath)
+		printDebug("Local chart %v, chart name: %v\n", release.ChartPath, release.Chart)
 	}
 
-	if *customValues != "" {
-		*customValues = " --values " + *customValues
+	// OCI charts may be passed either as "--chart oci://registry/path/chart"
+	// or as "--repository oci://registry/path --chart chart"
+	if isOCIReference(release.Repository) && release.Chart != "" && !isOCIReference(release.Chart) {
+		release.Chart = strings.TrimSuffix(release.Repository, "/") + "/" + release.Chart
+		release.Repository = ""
 	}
 
-	if *outputDir == "" {
-		*outputDir = *helmChart
+	if isOCIReference(release.Chart) {
+		if release.Namespace == "" {
+			fmt.Println("ERROR! Missing parameters. \"--namespace\" MUST be specified!")
+			exit(1)
+		}
+		if release.Repository != "" {
+			fmt.Println("ERROR! \"--repository\" cannot be used with an oci:// chart reference!")
+			exit(1)
+		}
+		release.ChartRef = release.Chart
+		release.Chart = ociChartName(release.ChartRef)
+		printDebug("OCI chart reference %v, chart name: %v\n", release.ChartRef, release.Chart)
+	} else if release.ChartPath == "" && (release.Namespace == "" || release.Chart == "" || release.Repository == "") {
+		fmt.Println("ERROR! Missing parameters. \"--namespace\", \"--repository\" and \"--chart\" MUST be specified!")
+		exit(1)
 	}
 
-	if skipCRDs {
-		*includeCRDsFlag = ""
-	} else {
-		*includeCRDsFlag = " --include-crds"
+	if release.Name == "" {
+		release.Name = release.Chart
+	}
+	if release.OutputDir == "" {
+		release.OutputDir = release.Name
 	}
 }
 
@@ -171,67 +471,132 @@ func parseConfig(customConfigFilePath string) configStruct {
 	return config
 }
 
-// Add and update helm repo, pull and template helm chart
-func execHelmCommands(helmChart, helmRepo, helmChartVersion, customValues, includeCRDsFlag, namespace string) {
-	printDebug("Adding helm repository\n")
-	execCommand("helm repo add", helmChart, helmRepo)
+// Add and update helm repo, pull and template helm chart.
+// OCI charts are pulled directly by reference, without touching the repo list.
+// Local charts are copied to the release's TmpDir and their dependencies are built there
+func execHelmCommands(helm HelmRunner, release *releaseStruct) {
+	chartDir := release.TmpDir + "/" + release.Chart
+
+	if release.ChartPath != "" {
+		printDebug("Copying local chart\n")
+		copyLocalChart(release.ChartPath, chartDir)
+		if isChartArchive(release.ChartPath) {
+			release.ChartDigest = fileDigest(release.ChartPath)
+		}
 
-	printDebug("Updating helm repository\n")
-	execCommand("helm repo update")
+		if chartHasDependencies(chartDir) {
+			absoluteFileDependencies(chartDir, release.ChartPath)
+			printDebug("Building chart dependencies\n")
+			// Building refreshes the caches of the user's repositories
+			helmRepoLock.Lock()
+			err := helm.DependencyBuild(chartDir)
+			helmRepoLock.Unlock()
+			exitOnHelmErr(err)
+		}
+	} else {
+		if release.ChartRef != "" {
+			pullChart(helm, release.ChartRef, release)
+		} else {
+			// Another release must not change the repositories between our update and pull
+			helmRepoLock.Lock()
+			alias := helmRepoAlias(release.Repository)
 
-	printDebug("Pulling helm chart\n")
-	execCommand("helm pull --untar --untardir "+tmpDir+helmChartVersion, helmChart+"/"+helmChart)
+			printDebug("Adding helm repository %v\n", alias)
+			exitOnHelmErr(helm.RepoAdd(alias, release.Repository))
 
-	printDebug("Templating helm chart\n")
-	execCommand("helm template"+customValues+includeCRDsFlag, "--namespace", namespace, helmChart, tmpDir+"/"+helmChart, "--output-dir", tmpDir+"/rendered")
-}
+			printDebug("Updating helm repository\n")
+			exitOnHelmErr(helm.RepoUpdate())
+
+			pullChart(helm, alias+"/"+release.Chart, release)
+			helmRepoLock.Unlock()
+		}
 
-func processRenderedDir(renderedDir string, config *configStruct, outputDir string) {
-	printDebug("Processing directory %v\n", renderedDir)
-	if fileIsAbsent(renderedDir) {
-		printDebug("Directory not found\n")
-		return
+		// Unpack the archive ourselves, so its digest can be locked
+		archive := pulledChartArchive(release.TmpDir)
+		release.ChartDigest = fileDigest(archive)
+		copyLocalChart(archive, chartDir)
 	}
+	release.ResolvedVersion = readChartMetadata(chartDir).Version
 
-	dir, err := os.Open(renderedDir)
-	checkErr(err)
-	dirInfo, err := dir.ReadDir(-1)
-	dir.Close()
-	checkErr(err)
+	printDebug("Templating helm chart\n")
+	exitOnHelmErr(helm.Template(release.Name, chartDir, TemplateOptions{
+		Namespace:   release.Namespace,
+		ValuesFiles: release.ValuesFiles,
+		SetValues:   release.SetValues,
+		KubeVersion: release.KubeVersion,
+		APIVersions: release.APIVersions,
+		IncludeCRDs: !release.SkipCRDs,
+		OutputDir:   release.TmpDir + "/rendered",
+	}))
+}
 
-	splitAndRename(renderedDir, outputDir, dirInfo, config)
+func pullChart(helm HelmRunner, chartRef string, release *releaseStruct) {
+	printDebug("Pulling helm chart\n")
+	exitOnHelmErr(helm.Pull(chartRef, PullOptions{
+		Version: release.Version,
+		DestDir: release.TmpDir,
+	}))
 }
 
-func splitAndRename(renderedDir, subchartDir string, dirInfo []fs.DirEntry, config *configStruct) {
-	// Iterate over all rendered files
-	for _, file := range dirInfo {
-		inputFile := renderedDir + "/" + file.Name()
+// Split rendered files into manifests and decide where each one goes
+func splitAndRename(renderedFiles []renderedFileStruct, release *releaseStruct, config *configStruct) []*outputManifestStruct {
+	manifests := collectManifests(renderedFiles, release)
+	manifests = applyHookPolicies(manifests, config.Hooks)
+	transformHooks(manifests, release, config.HookTransform)
+	if usesFlux(config) {
+		// Without kustomization.yaml Flux applies every file under the path, hooks included
+		printDebug("Flux needs nested kustomizations, enabling them\n")
+		release.Kustomization.Enabled = true
+		release.Kustomization.Nested = true
+	}
+	assignShortcuts(manifests, config)
+	resolveFilenames(manifests, config)
 
-		printDebug("Checking file %v\n", inputFile)
+	if release.Kustomization.Enabled {
+		manifests = append(manifests, generateKustomizations(manifests, &release.Kustomization)...)
+	}
+	switch {
+	case usesFlux(config):
+		manifests = append(manifests, fluxKustomizations(manifests, release, &config.GitOps))
+	case config.GitOps.Generate == gitOpsArgoCD:
+		manifests = append(manifests, argoCDApplication(manifests, release, &config.GitOps))
+	}
 
-		if file.IsDir() {
-			printDebug("It is a directory\n")
-			dir, err := os.Open(inputFile)
-			checkErr(err)
-			subDirInfo, err := dir.ReadDir(-1)
-			dir.Close()
-			checkErr(err)
+	return manifests
+}
 
-			splitAndRename(inputFile, subchartDir+"/"+file.Name(), subDirInfo, config)
-			continue
-		}
+// Split all rendered files into manifests
+func collectManifests(renderedFiles []renderedFileStruct, release *releaseStruct) []*outputManifestStruct {
+	var manifests []*outputManifestStruct
 
-		yamlFile, err := os.ReadFile(inputFile)
-		checkErr(err)
+	// Iterate over all rendered files
+	for _, file := range renderedFiles {
+		printDebug("Checking file %v\n", file.Path)
+
+		// By default files are written to the same subdirectory
+		// they had in templates/ or crds/ of the chart
+		subchartDir := ""
+		if _, relPath, found := strings.Cut(file.Path, "/"); found {
+			if dir := path.Dir(relPath); dir != "." {
+				subchartDir = dir
+			}
+		}
 
 		// Split yamls containing multiple manifests
-		yamlSlice := regexp.MustCompile("(?m)^(---[[:space:]]*)$").Split(string(yamlFile), -1)
+		documents, err := splitDocuments(file.Content)
+		if err == nil && !keepLists {
+			documents, err = expandLists(documents)
+		}
+		if err != nil {
+			fmt.Printf("ERROR! Cannot parse %v: %v\n", file.Path, err)
+			exit(1)
+		}
 
-		for _, manifest := range yamlSlice[1:] {
+		for _, document := range documents {
 			var obj ManifestStruct
-			manifestByte := []byte("---" + manifest)
+			manifestByte := append([]byte("---\n"), document.Content...)
 
-			err = yaml.Unmarshal(manifestByte, &obj)
+			err = document.Node.Decode(&obj)
 			checkErr(err)
 
 			if obj.Kind == "" {
@@ -239,65 +604,98 @@ func splitAndRename(renderedDir, subchartDir string, dirInfo []fs.DirEntry, conf
 				continue
 			}
 
-			shortcut := config.Shortcuts[obj.Kind]
-			if shortcut == "" {
-				fmt.Printf("ERROR! Unknown kind \"%v\"! Add a shortcut for this kind to %v and rerun!\n", obj.Kind, config.FilePath)
-				printDebug("Caused by this manifest:\n%v", string(manifestByte))
-				exit(1)
-			}
+			manifests = append(manifests, &outputManifestStruct{
+				Release:    release.Name,
+				Kind:       obj.Kind,
+				APIVersion: obj.APIVersion,
+				Name:       obj.Metadata.Name,
+				Namespace:  obj.Metadata.Namespace,
+				Hooks:      parseHookTypes(obj.Metadata.Annotations[hookAnnotation]),
+				Source:     file.Path,
+				OutputDir:  release.OutputDir,
+				Subchart:   subchartDir,
+				Content:    manifestByte,
+				Node:       document.Node,
+			})
+		}
+	}
 
-			manifestName := obj.Metadata.Name
+	return manifests
+}
 
-			if fileIsAbsent(subchartDir) {
-				printDebug("Creating directory " + subchartDir + "\n")
-				os.MkdirAll(subchartDir, 0755)
-			}
+func writeManifests(manifests []*outputManifestStruct) {
+	for _, manifest := range manifests {
+		if dir := path.Dir(manifest.Filename); fileIsAbsent(dir) {
+			printDebug("Creating directory " + dir + "\n")
+			os.MkdirAll(dir, 0755)
+		}
 
-			outputFilename := fmt.Sprintf("%v/%v-%v.yaml", subchartDir, shortcut, manifestName)
-			fmt.Println("Generating", outputFilename)
+		outputFilename := manifest.Filename
+		fmt.Println("Generating", outputFilename)
 
-			if !fileIsAbsent(outputFilename) {
-				if overwrite {
-					printDebug("WARNING! File %v is present. Continue anyway, because --overwrite was provided\n", outputFilename)
-				} else {
-					fmt.Printf("ERROR! File %v is present. Use --overwrite if you want to skip this error. Exiting...\n", outputFilename)
-					exit(1)
-				}
+		if !fileIsAbsent(outputFilename) {
+			if overwrite {
+				printDebug("WARNING! File %v is present. Continue anyway, because --overwrite was provided\n", outputFilename)
+			} else {
+				fmt.Printf("ERROR! File %v is present. Use --overwrite if you want to skip this error. Exiting...\n", outputFilename)
+				exit(1)
 			}
-
-			err = os.WriteFile(outputFilename, manifestByte, 0644)
-			checkErr(err)
 		}
+
+		err := os.WriteFile(outputFilename, manifest.Content, 0644)
+		checkErr(err)
 	}
 }
 
-func fileIsAbsent(filename string) bool {
-	_, err := os.Stat(filename)
-	return os.IsNotExist(err)
+func isOCIReference(ref string) bool {
+	return strings.HasPrefix(ref, "oci://")
 }
 
-func execCommand(command ...string) {
-	joinedCommand := strings.Join(command, " ")
-	args := strings.Split(joinedCommand, " ")
+// Get the chart name from an OCI reference, e.g.
+// oci://registry:5000/path/chart:1.2.3@sha256:abc -> chart
+func ociChartName(ref string) string {
+	name := strings.TrimSuffix(strings.TrimPrefix(ref, "oci://"), "/")
+	name = name[strings.LastIndex(name, "/")+1:]
+
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
 
-	printDebug("Running command: %v\n", args)
+	return name
+}
 
-	cmd := exec.Command(args[0], args[1:]...)
-	output, err := cmd.CombinedOutput()
+func fileIsAbsent(filename string) bool {
+	_, err := os.Stat(filename)
+	return os.IsNotExist(err)
+}
 
-	printDebug(string(output))
+// Create the temporary directory of the run and one for every release
+func createTmpDirs(releases []*releaseStruct) {
+	var err error
+	tmpDir, err = os.MkdirTemp("", "helm-splitter-")
 	if err != nil {
-		if !debug {
-			fmt.Println(string(output))
-		}
-		fmt.Println(err)
+		fmt.Printf("ERROR! Cannot create a temporary directory: %v\n", err)
 		exit(1)
 	}
+	printDebug("Temporary directory: %v\n", tmpDir)
+
+	for i, release := range releases {
+		release.TmpDir = fmt.Sprintf("%v/%v", tmpDir, i)
+		err = os.Mkdir(release.TmpDir, 0755)
+		checkErr(err)
+	}
 }
 
 func exit(exitCode int) {
-	if !debug {
-		os.RemoveAll(tmpDir)
+	if tmpDir != "" {
+		if debug {
+			fmt.Printf("Temporary files are kept in %v\n", tmpDir)
+		} else {
+			os.RemoveAll(tmpDir)
+		}
 	}
 	os.Exit(exitCode)
 }
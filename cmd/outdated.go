@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Masterminds/semver/v3"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// Only the part of a repository index.yaml needed to list chart versions
+type RepoIndexStruct struct {
+	Entries map[string][]struct {
+		Version string `yaml:"version"`
+	} `yaml:"entries"`
+}
+
+type OutdatedStruct struct {
+	Release       string `json:"release"`
+	Chart         string `json:"chart"`
+	Repository    string `json:"repository"`
+	Current       string `json:"current"`
+	Latest        string `json:"latest"`
+	LatestInMajor string `json:"latestInMajor"`
+	Outdated      bool   `json:"outdated"`
+}
+
+// Report the pinned and the latest available versions of every release.
+// The pinned version is the one given in the project file or with --version,
+// otherwise the one recorded in the lock file
+func reportOutdated(releases []*releaseStruct, lockFile string) {
+	locked := map[string]string{}
+	for _, entry := range readLock(lockFile).Releases {
+		locked[entry.Name] = entry.Version
+	}
+
+	settings := cli.New()
+	indexes := map[string]*RepoIndexStruct{}
+	var report []OutdatedStruct
+
+	for _, release := range releases {
+		if release.ChartPath != "" {
+			printDebug("Skipping local chart %v\n", release.ChartPath)
+			continue
+		}
+
+		current := release.Version
+		if current == "" {
+			current = locked[release.Name]
+		}
+
+		versions := chartVersions(release, settings, indexes)
+
+		outdated := OutdatedStruct{
+			Release:       release.Name,
+			Chart:         release.Chart,
+			Repository:    lockRepository(release),
+			Current:       current,
+			Latest:        latestVersion(versions, nil),
+			LatestInMajor: latestVersionInMajor(versions, current),
+		}
+		outdated.Outdated = isOlderVersion(current, outdated.Latest)
+		report = append(report, outdated)
+	}
+
+	if jsonOutput {
+		if report == nil {
+			report = []OutdatedStruct{}
+		}
+		reportByte, err := json.MarshalIndent(report, "", "  ")
+		checkErr(err)
+		fmt.Println(string(reportByte))
+		return
+	}
+
+	printOutdated(os.Stdout, report)
+}
+
+func printOutdated(output io.Writer, report []OutdatedStruct) {
+	table := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "RELEASE\tCHART\tCURRENT\tLATEST IN MAJOR\tLATEST\t")
+	for _, outdated := range report {
+		fmt.Fprintf(table, "%v\t%v\t%v\t%v\t%v\t%v\n", outdated.Release, outdated.Chart,
+			orDash(outdated.Current), orDash(outdated.LatestInMajor), orDash(outdated.Latest), outdatedMark(outdated))
+	}
+	table.Flush()
+}
+
+func orDash(text string) string {
+	if text == "" {
+		return "-"
+	}
+	return text
+}
+
+func outdatedMark(outdated OutdatedStruct) string {
+	if outdated.Outdated {
+		return "outdated"
+	}
+	return ""
+}
+
+// List versions of a repository or OCI chart. Indexes are cached by repository
+func chartVersions(release *releaseStruct, settings *cli.EnvSettings, indexes map[string]*RepoIndexStruct) []*semver.Version {
+	printDebug("Listing versions of chart %v\n", release.Chart)
+
+	if release.ChartRef != "" {
+		return ociChartVersions(release.ChartRef, settings)
+	}
+
+	if indexes[release.Repository] == nil {
+		indexes[release.Repository] = fetchRepoIndex(release.Repository, settings)
+	}
+	return indexChartVersions(indexes[release.Repository], release.Chart, release.Repository)
+}
+
+func fetchRepoIndex(repository string, settings *cli.EnvSettings) *RepoIndexStruct {
+	indexURL := strings.TrimSuffix(repository, "/") + "/index.yaml"
+
+	parsedURL, err := url.Parse(indexURL)
+	exitOnSDKErr(err)
+	repoGetter, err := getter.All(settings).ByScheme(parsedURL.Scheme)
+	exitOnSDKErr(err)
+
+	printDebug("Downloading %v\n", indexURL)
+	indexByte, err := repoGetter.Get(indexURL, getter.WithURL(repository))
+	if err != nil {
+		fmt.Printf("ERROR! Cannot download index of repository %v: %v\n", repository, err)
+		exit(1)
+	}
+
+	var index RepoIndexStruct
+	if err := yaml.Unmarshal(indexByte.Bytes(), &index); err != nil {
+		fmt.Printf("ERROR! Cannot parse index of repository %v: %v\n", repository, err)
+		exit(1)
+	}
+	return &index
+}
+
+func indexChartVersions(index *RepoIndexStruct, chart, repository string) []*semver.Version {
+	entries, found := index.Entries[chart]
+	if !found {
+		fmt.Printf("ERROR! Chart %v not found in repository %v!\n", chart, repository)
+		exit(1)
+	}
+
+	var versions []*semver.Version
+	for _, entry := range entries {
+		if chartVersion, err := semver.NewVersion(entry.Version); err == nil {
+			versions = append(versions, chartVersion)
+		}
+	}
+	return versions
+}
+
+func ociChartVersions(chartRef string, settings *cli.EnvSettings) []*semver.Version {
+	registryClient, err := registry.NewClient(
+		registry.ClientOptDebug(debug),
+		registry.ClientOptWriter(io.Discard),
+		registry.ClientOptCredentialsFile(settings.RegistryConfig),
+	)
+	exitOnSDKErr(err)
+
+	// Tags are listed for the chart itself, without a version or digest
+	ref := strings.TrimPrefix(chartRef, "oci://")
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+
+	tags, err := registryClient.Tags(ref)
+	if err != nil {
+		fmt.Printf("ERROR! Cannot list tags of %v: %v\n", chartRef, err)
+		exit(1)
+	}
+
+	var versions []*semver.Version
+	for _, tag := range tags {
+		if chartVersion, err := semver.NewVersion(tag); err == nil {
+			versions = append(versions, chartVersion)
+		}
+	}
+	return versions
+}
+
+// The highest stable version, matching the filter if it is given
+func latestVersion(versions []*semver.Version, filter func(*semver.Version) bool) string {
+	var latest *semver.Version
+	for _, chartVersion := range versions {
+		if chartVersion.Prerelease() != "" || (filter != nil && !filter(chartVersion)) {
+			continue
+		}
+		if latest == nil || chartVersion.GreaterThan(latest) {
+			latest = chartVersion
+		}
+	}
+
+	if latest == nil {
+		return ""
+	}
+	return latest.Original()
+}
+
+func latestVersionInMajor(versions []*semver.Version, current string) string {
+	currentVersion, err := semver.NewVersion(current)
+	if err != nil {
+		return ""
+	}
+	return latestVersion(versions, func(chartVersion *semver.Version) bool {
+		return chartVersion.Major() == currentVersion.Major()
+	})
+}
+
+// An unpinned or unparsable current version is never outdated
+func isOlderVersion(current, latest string) bool {
+	currentVersion, err := semver.NewVersion(current)
+	if err != nil {
+		return false
+	}
+	latestVersion, err := semver.NewVersion(latest)
+	if err != nil {
+		return false
+	}
+	return currentVersion.LessThan(latestVersion)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/cli"
+)
+
+const testRepoIndex = `apiVersion: v1
+entries:
+  demo:
+    - version: 1.2.0
+    - version: 1.10.0
+    - version: 1.4.1
+    - version: 2.0.0
+    - version: 2.1.0-rc.1
+    - version: not-a-version
+  other:
+    - version: 0.1.0
+`
+
+func TestRepoIndexVersions(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+		if request.URL.Path != "/charts/index.yaml" {
+			http.NotFound(writer, request)
+			return
+		}
+		writer.Write([]byte(testRepoIndex))
+	}))
+	defer server.Close()
+
+	repository := server.URL + "/charts/"
+	index := fetchRepoIndex(repository, cli.New())
+	if len(index.Entries) != 2 {
+		t.Fatalf("index has %v charts, want 2", len(index.Entries))
+	}
+
+	versions := indexChartVersions(index, "demo", repository)
+	if got, want := versionStrings(versions), []string{"1.10.0", "1.2.0", "1.4.1", "2.0.0", "2.1.0-rc.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("versions: got %v, want %v", got, want)
+	}
+
+	// The index is downloaded once per repository
+	release := &releaseStruct{Chart: "other", Repository: repository}
+	indexes := map[string]*RepoIndexStruct{}
+	for i := 0; i < 2; i++ {
+		if got := versionStrings(chartVersions(release, cli.New(), indexes)); !reflect.DeepEqual(got, []string{"0.1.0"}) {
+			t.Errorf("versions of other: got %v", got)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("index downloaded %v times, want 2", requests)
+	}
+
+	if got := latestVersion(versions, nil); got != "2.0.0" {
+		t.Errorf("latest version: got %v, want 2.0.0", got)
+	}
+
+	tests := []struct {
+		current       string
+		latestInMajor string
+		outdated      bool
+	}{
+		{current: "1.2.0", latestInMajor: "1.10.0", outdated: true},
+		{current: "1.10.0", latestInMajor: "1.10.0", outdated: true},
+		{current: "2.0.0", latestInMajor: "2.0.0", outdated: false},
+		{current: "2.1.0-rc.1", latestInMajor: "2.0.0", outdated: false},
+		{current: "3.0.0", latestInMajor: "", outdated: false},
+		// Not pinned and not locked: nothing to compare with
+		{current: "", latestInMajor: "", outdated: false},
+	}
+	for _, test := range tests {
+		if got := latestVersionInMajor(versions, test.current); got != test.latestInMajor {
+			t.Errorf("latest version in the major of %q: got %q, want %q", test.current, got, test.latestInMajor)
+		}
+		if got := isOlderVersion(test.current, "2.0.0"); got != test.outdated {
+			t.Errorf("%q is older than 2.0.0: got %v, want %v", test.current, got, test.outdated)
+		}
+	}
+}
+
+func versionStrings(versions []*semver.Version) []string {
+	var texts []string
+	for _, version := range versions {
+		texts = append(texts, version.Original())
+	}
+	sort.Strings(texts)
+	return texts
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultProjectFile = "splitter.yaml"
+
+// A project file lists releases to render in one run.
+// Relative paths are relative to the directory of the project file
+type ProjectStruct struct {
+	Config   string                 `yaml:"config"`
+	Releases []ProjectReleaseStruct `yaml:"releases"`
+}
+
+type ProjectReleaseStruct struct {
+	Name          string                     `yaml:"name"`
+	Chart         string                     `yaml:"chart"`
+	Repository    string                     `yaml:"repository"`
+	ChartPath     string                     `yaml:"chartPath"`
+	Version       string                     `yaml:"version"`
+	Namespace     string                     `yaml:"namespace"`
+	ValuesFiles   []string                   `yaml:"valuesFiles"`
+	Set           []string                   `yaml:"set"`
+	SetString     []string                   `yaml:"setString"`
+	SetFile       []string                   `yaml:"setFile"`
+	SetJSON       []string                   `yaml:"setJSON"`
+	OutputDir     string                     `yaml:"outputDir"`
+	Cluster       string                     `yaml:"cluster"`
+	KubeVersion   string                     `yaml:"kubeVersion"`
+	APIVersions   []string                   `yaml:"apiVersions"`
+	SkipCRDs      bool                       `yaml:"skipCRDs"`
+	Kustomization ProjectKustomizationStruct `yaml:"kustomization"`
+}
+
+type ProjectKustomizationStruct struct {
+	Enabled   bool              `yaml:"enabled"`
+	Namespace string            `yaml:"namespace"`
+	Labels    map[string]string `yaml:"labels"`
+	Nested    bool              `yaml:"nested"`
+}
+
+func readProject(projectFile string) ProjectStruct {
+	var project ProjectStruct
+
+	projectByte, err := os.ReadFile(projectFile)
+	if err != nil {
+		fmt.Printf("ERROR! Cannot read project file: %v\n", err)
+		exit(1)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(projectByte))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&project); err != nil {
+		fmt.Printf("ERROR! Cannot parse %v: %v\n", projectFile, err)
+		exit(1)
+	}
+
+	if len(project.Releases) == 0 {
+		fmt.Printf("ERROR! No releases in %v!\n", projectFile)
+		exit(1)
+	}
+
+	if project.Config != "" {
+		project.Config = projectPath(projectFile, project.Config)
+	}
+
+	return project
+}
+
+// Turn the releases of a project into releases to render. Boolean flags
+// given on the command line are defaults for all releases
+func projectReleases(projectFile string, project *ProjectStruct, defaults *releaseStruct, rendererName string) []*releaseStruct {
+	var releases []*releaseStruct
+	names := map[string]bool{}
+	outputDirs := map[string]string{}
+
+	for i, projectRelease := range project.Releases {
+		release := &releaseStruct{
+			Name:        projectRelease.Name,
+			Namespace:   projectRelease.Namespace,
+			Repository:  projectRelease.Repository,
+			Chart:       projectRelease.Chart,
+			Version:     projectRelease.Version,
+			Cluster:     defaults.Cluster,
+			KubeVersion: defaults.KubeVersion,
+			APIVersions: append(append(apiVersionsValue{}, defaults.APIVersions...), projectRelease.APIVersions...),
+			SkipCRDs:    projectRelease.SkipCRDs || defaults.SkipCRDs,
+			Kustomization: kustomizationOptionsStruct{
+				Enabled:      projectRelease.Kustomization.Enabled || defaults.Kustomization.Enabled,
+				Namespace:    defaults.Kustomization.Namespace,
+				CommonLabels: defaults.Kustomization.CommonLabels,
+				Nested:       projectRelease.Kustomization.Nested || defaults.Kustomization.Nested,
+			},
+		}
+		if projectRelease.Kustomization.Namespace != "" {
+			release.Kustomization.Namespace = projectRelease.Kustomization.Namespace
+		}
+		if projectRelease.Kustomization.Labels != nil {
+			release.Kustomization.CommonLabels = projectRelease.Kustomization.Labels
+		}
+		if projectRelease.Cluster != "" {
+			release.Cluster = projectRelease.Cluster
+		}
+		if projectRelease.KubeVersion != "" {
+			release.KubeVersion = projectRelease.KubeVersion
+		}
+		if projectRelease.ChartPath != "" {
+			release.ChartPath = projectPath(projectFile, projectRelease.ChartPath)
+		}
+		for _, valuesFile := range projectRelease.ValuesFiles {
+			release.ValuesFiles = append(release.ValuesFiles, projectPath(projectFile, valuesFile))
+		}
+		release.SetValues = projectSetValues(projectFile, &projectRelease)
+
+		if release.Namespace == "" || (release.Chart == "" && release.ChartPath == "") ||
+			(release.ChartPath == "" && release.Repository == "" && !isOCIReference(release.Chart)) {
+			fmt.Printf("ERROR! Release #%v in %v must have \"namespace\", and \"chart\" with \"repository\", an oci:// \"chart\" or \"chartPath\"!\n", i+1, projectFile)
+			exit(1)
+		}
+
+		validateInputParams(release, rendererName)
+
+		if names[release.Name] {
+			fmt.Printf("ERROR! Release %v is listed twice in %v, set a unique \"name\"!\n", release.Name, projectFile)
+			exit(1)
+		}
+		names[release.Name] = true
+
+		release.OutputDir = projectPath(projectFile, release.Name)
+		if projectRelease.OutputDir != "" {
+			release.OutputDir = projectPath(projectFile, projectRelease.OutputDir)
+		}
+		if otherRelease, found := outputDirs[release.OutputDir]; found {
+			fmt.Printf("ERROR! Releases %v and %v have the same output directory %v!\n", otherRelease, release.Name, release.OutputDir)
+			exit(1)
+		}
+		outputDirs[release.OutputDir] = release.Name
+
+		releases = append(releases, release)
+	}
+
+	return releases
+}
+
+// Set values of a project release, in the order helm applies them.
+// Files of setFile are relative to the project file too
+func projectSetValues(projectFile string, projectRelease *ProjectReleaseStruct) []setValueStruct {
+	var setValues []setValueStruct
+	add := func(flagName string, values []string) {
+		for _, value := range values {
+			if err := validateSetValue(flagName, value); err != nil {
+				fmt.Printf("ERROR! Invalid %v of release %v in %v: %v\n", flagName, projectRelease.Name, projectFile, err)
+				exit(1)
+			}
+			if flagName == setFileFlag {
+				key, filename, _ := strings.Cut(value, "=")
+				value = key + "=" + projectPath(projectFile, filename)
+			}
+			setValues = append(setValues, setValueStruct{Flag: flagName, Value: value})
+		}
+	}
+
+	add(setJSONFlag, projectRelease.SetJSON)
+	add(setFlag, projectRelease.Set)
+	add(setStringFlag, projectRelease.SetString)
+	add(setFileFlag, projectRelease.SetFile)
+	return setValues
+}
+
+// Resolve a path of the project file relative to its directory
+func projectPath(projectFile, filename string) string {
+	if path.IsAbs(filename) {
+		return filename
+	}
+	return path.Join(path.Dir(projectFile), filename)
+}
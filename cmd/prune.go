@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The state file lists files generated by the last run with --prune,
+// so the next run knows which files it may delete
+const stateFilename = ".helm-splitter-state.yaml"
+
+type StateStruct struct {
+	Files []string `yaml:"files"`
+}
+
+func readState(outputDir string) StateStruct {
+	var state StateStruct
+
+	stateByte, err := os.ReadFile(path.Join(outputDir, stateFilename))
+	if os.IsNotExist(err) {
+		printDebug("No state file found in %v\n", outputDir)
+		return state
+	}
+	checkErr(err)
+
+	err = yaml.Unmarshal(stateByte, &state)
+	if err != nil {
+		fmt.Printf("ERROR! Cannot parse %v: %v\n", path.Join(outputDir, stateFilename), err)
+		exit(1)
+	}
+
+	return state
+}
+
+func writeState(outputDir string, state StateStruct) {
+	sort.Strings(state.Files)
+
+	err := os.MkdirAll(outputDir, 0755)
+	checkErr(err)
+
+	content := append([]byte("# Generated by helm-splitter, used by --prune. Do not edit\n"), marshalYaml(&state)...)
+	err = os.WriteFile(path.Join(outputDir, stateFilename), content, 0644)
+	checkErr(err)
+}
+
+// Files generated by this run, relative to the output directory
+func generatedFiles(manifests []*outputManifestStruct, outputDir string) []string {
+	var files []string
+	for _, manifest := range manifests {
+		relPath, err := filepath.Rel(outputDir, manifest.Filename)
+		checkErr(err)
+		files = append(files, filepath.ToSlash(relPath))
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Files generated by a previous run, but not by this one.
+// Only files listed in the state file are considered,
+// so files created by anyone else are never touched
+func staleFiles(manifests []*outputManifestStruct, outputDir string) []string {
+	current := map[string]bool{}
+	for _, file := range generatedFiles(manifests, outputDir) {
+		current[file] = true
+	}
+
+	var stale []string
+	for _, file := range readState(outputDir).Files {
+		if current[file] || !filepath.IsLocal(file) {
+			continue
+		}
+		if fileIsAbsent(path.Join(outputDir, file)) {
+			continue
+		}
+		stale = append(stale, file)
+	}
+	return stale
+}
+
+// Remove files of previous runs which were not generated by this run,
+// then record the files of this run in the state file
+func pruneStaleFiles(manifests []*outputManifestStruct, outputDir string, dryRun bool) {
+	for _, file := range staleFiles(manifests, outputDir) {
+		filename := path.Join(outputDir, file)
+
+		if dryRun {
+			fmt.Println("Would prune", filename)
+			continue
+		}
+
+		fmt.Println("Pruning", filename)
+		err := os.Remove(filename)
+		checkErr(err)
+		removeEmptyDirs(path.Dir(filename), outputDir)
+	}
+
+	if !dryRun {
+		writeState(outputDir, StateStruct{Files: generatedFiles(manifests, outputDir)})
+	}
+}
+
+// Remove dir and its parents up to outputDir while they are empty
+func removeEmptyDirs(dir, outputDir string) {
+	for dir != path.Clean(outputDir) && dir != "." && dir != "/" {
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			return
+		}
+
+		printDebug("Removing empty directory %v\n", dir)
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPruneStaleFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		state     []string
+		files     []string // in the output directory, "../" for files outside of it
+		generated []string
+		dryRun    bool
+		wantFiles []string
+		wantState []string
+	}{
+		{
+			name:      "files missing from the state are kept",
+			state:     []string{"svc-a.yaml", "deploy-old.yaml"},
+			files:     []string{"svc-a.yaml", "deploy-old.yaml", "handmade.yaml"},
+			generated: []string{"svc-a.yaml"},
+			wantFiles: []string{"handmade.yaml", "svc-a.yaml"},
+			wantState: []string{"svc-a.yaml"},
+		},
+		{
+			name:      "entries outside the output directory are ignored",
+			state:     []string{"svc-a.yaml", "../x.yaml", "/tmp/y.yaml"},
+			files:     []string{"svc-a.yaml", "../x.yaml"},
+			generated: []string{"svc-a.yaml"},
+			wantFiles: []string{"../x.yaml", "svc-a.yaml"},
+			wantState: []string{"svc-a.yaml"},
+		},
+		{
+			name:      "dry run removes nothing",
+			state:     []string{"svc-a.yaml", "deploy-old.yaml"},
+			files:     []string{"svc-a.yaml", "deploy-old.yaml"},
+			generated: []string{"svc-a.yaml", "cm-new.yaml"},
+			dryRun:    true,
+			wantFiles: []string{"deploy-old.yaml", "svc-a.yaml"},
+			wantState: []string{"deploy-old.yaml", "svc-a.yaml"},
+		},
+		{
+			name:      "empty directories are removed up to the output directory",
+			state:     []string{"team/app/deploy-old.yaml", "team/other/svc-b.yaml"},
+			files:     []string{"team/app/deploy-old.yaml", "team/other/svc-b.yaml"},
+			generated: []string{"team/other/svc-b.yaml"},
+			wantFiles: []string{"team/other/svc-b.yaml"},
+			wantState: []string{"team/other/svc-b.yaml"},
+		},
+		{
+			name:  "the output directory itself is kept",
+			state: []string{"nested/deploy-old.yaml"},
+			files: []string{"nested/deploy-old.yaml"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outputDir := filepath.Join(t.TempDir(), "out")
+			writeState(outputDir, StateStruct{Files: test.state})
+			stateBefore := readTestFile(t, filepath.Join(outputDir, stateFilename))
+			for _, file := range test.files {
+				writeTestFile(t, filepath.Join(outputDir, file), "kind: ConfigMap\n")
+			}
+
+			var manifests []*outputManifestStruct
+			for _, file := range test.generated {
+				manifests = append(manifests, &outputManifestStruct{Filename: filepath.Join(outputDir, file)})
+			}
+			pruneStaleFiles(manifests, outputDir, test.dryRun)
+
+			if fileIsAbsent(outputDir) {
+				t.Fatalf("output directory is removed")
+			}
+			if got := listTestFiles(t, filepath.Dir(outputDir), outputDir); !reflect.DeepEqual(got, test.wantFiles) {
+				t.Errorf("files: got %q, want %q", got, test.wantFiles)
+			}
+			if got := readState(outputDir).Files; strings.Join(got, ",") != strings.Join(test.wantState, ",") {
+				t.Errorf("state: got %q, want %q", got, test.wantState)
+			}
+			if stateAfter := readTestFile(t, filepath.Join(outputDir, stateFilename)); test.dryRun && stateAfter != stateBefore {
+				t.Errorf("dry run changed the state file:\n%v", stateAfter)
+			}
+		})
+	}
+}
+
+// Files and empty directories under root, except the state file,
+// relative to outputDir
+func listTestFiles(t *testing.T, root, outputDir string) []string {
+	t.Helper()
+
+	var files []string
+	err := filepath.WalkDir(root, func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.Name() == stateFilename || filename == root || filename == outputDir {
+			return err
+		}
+		if entry.IsDir() {
+			entries, err := filepath.Glob(filepath.Join(filename, "*"))
+			if err != nil || len(entries) > 0 {
+				return err
+			}
+		}
+
+		relPath, err := filepath.Rel(outputDir, filename)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(relPath))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(files)
+	return files
+}
+
+func readTestFile(t *testing.T, filename string) string {
+	t.Helper()
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
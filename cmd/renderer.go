@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const rendererExec = "exec"
+const rendererSDK = "sdk"
+
+// A file rendered from a chart template. Path is relative to the chart root,
+// e.g. templates/prometheus/deployment.yaml or crds/crd.yaml
+type renderedFileStruct struct {
+	Path    string
+	Content []byte
+}
+
+// Renderer turns a release into rendered chart files
+type Renderer interface {
+	Render(release *releaseStruct) []renderedFileStruct
+}
+
+// Render releases with up to parallelism workers.
+// The result has the rendered files of every release in the same order
+func renderReleases(renderer Renderer, releases []*releaseStruct, parallelism int) [][]renderedFileStruct {
+	renderedReleases := make([][]renderedFileStruct, len(releases))
+	jobs := make(chan int)
+
+	var workers sync.WaitGroup
+	for range min(parallelism, len(releases)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range jobs {
+				printDebug("Rendering release %v\n", releases[i].Name)
+				renderedReleases[i] = renderer.Render(releases[i])
+			}
+		}()
+	}
+
+	for i := range releases {
+		jobs <- i
+	}
+	close(jobs)
+	workers.Wait()
+
+	return renderedReleases
+}
+
+func newRenderer(rendererName, helmBinary string) Renderer {
+	if rendererName == rendererSDK {
+		return &sdkRenderer{}
+	}
+	return &execRenderer{helm: newExecHelmRunner(helmBinary)}
+}
+
+// execRenderer runs the helm binary and reads the files it rendered to the release's TmpDir
+type execRenderer struct {
+	helm HelmRunner
+}
+
+func (r *execRenderer) Render(release *releaseStruct) []renderedFileStruct {
+	execHelmCommands(r.helm, release)
+
+	chartRenderedDir := release.TmpDir + "/rendered/" + release.Chart
+
+	var renderedFiles []renderedFileStruct
+	renderedFiles = append(renderedFiles, readRenderedDir(chartRenderedDir, "templates")...)
+	renderedFiles = append(renderedFiles, readRenderedDir(chartRenderedDir, "crds")...)
+	return renderedFiles
+}
+
+func readRenderedDir(chartRenderedDir, subDir string) []renderedFileStruct {
+	var renderedFiles []renderedFileStruct
+
+	renderedDir := chartRenderedDir + "/" + subDir
+	printDebug("Processing directory %v\n", renderedDir)
+	if fileIsAbsent(renderedDir) {
+		printDebug("Directory not found\n")
+		return nil
+	}
+
+	err := filepath.WalkDir(renderedDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(chartRenderedDir, path)
+		if err != nil {
+			return err
+		}
+
+		renderedFiles = append(renderedFiles, renderedFileStruct{Path: filepath.ToSlash(relPath), Content: content})
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("ERROR! Cannot read rendered directory %v: %v\n", renderedDir, err)
+		exit(1)
+	}
+
+	return renderedFiles
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// sdkRenderer renders charts in-process with the helm SDK, the same way
+// "helm template" does. The helm binary and the user's repository list are
+// not used: repository charts are fetched by URL, and both the repository
+// list and the cache live in the release's TmpDir
+type sdkRenderer struct{}
+
+func (r *sdkRenderer) Render(release *releaseStruct) []renderedFileStruct {
+	settings := cli.New()
+	settings.Debug = debug
+	settings.RepositoryCache = release.TmpDir + "/cache"
+	// Never created: helm would look for the cached index of every repository
+	// in the user's list, and the cache above has none of them
+	settings.RepositoryConfig = release.TmpDir + "/repositories.yaml"
+
+	registryClient, err := registry.NewClient(
+		registry.ClientOptDebug(debug),
+		registry.ClientOptWriter(io.Discard),
+		registry.ClientOptCredentialsFile(settings.RegistryConfig),
+	)
+	exitOnSDKErr(err)
+
+	config := &action.Configuration{
+		RegistryClient: registryClient,
+		Log: func(format string, v ...interface{}) {
+			printDebug(append([]interface{}{format + "\n"}, v...)...)
+		},
+	}
+
+	install := action.NewInstall(config)
+	install.SetRegistryClient(registryClient)
+	install.DryRun = true
+	install.DryRunOption = "client"
+	install.ClientOnly = true
+	install.Replace = true
+	install.IncludeCRDs = !release.SkipCRDs
+	install.ReleaseName = release.Name
+	install.Namespace = release.Namespace
+	install.Version = release.Version
+	install.APIVersions = chartutil.VersionSet(release.APIVersions)
+	if release.KubeVersion != "" {
+		install.KubeVersion, err = chartutil.ParseKubeVersion(release.KubeVersion)
+		exitOnSDKErr(err)
+	}
+
+	printDebug("Loading helm chart\n")
+	loadedChart := r.loadChart(install, release, settings, registryClient)
+
+	chartValues, err := releaseValuesOptions(release).MergeValues(getter.All(settings))
+	exitOnSDKErr(err)
+
+	printDebug("Templating helm chart\n")
+	rel, err := install.Run(loadedChart, chartValues)
+	exitOnSDKErr(err)
+
+	// Put hooks after regular manifests, like "helm template" does
+	var manifests strings.Builder
+	manifests.WriteString(rel.Manifest)
+	for _, hook := range rel.Hooks {
+		fmt.Fprintf(&manifests, "\n---\n# Source: %s\n%s\n", hook.Path, hook.Manifest)
+	}
+
+	return groupManifestsBySource(release.Chart, manifests.String())
+}
+
+func (r *sdkRenderer) loadChart(install *action.Install, release *releaseStruct, settings *cli.EnvSettings, registryClient *registry.Client) *chart.Chart {
+	var chartPath string
+	var err error
+
+	switch {
+	case release.ChartPath != "":
+		// Build dependencies in a copy, so the user's chart stays untouched
+		chartPath = release.TmpDir + "/" + release.Chart
+		copyLocalChart(release.ChartPath, chartPath)
+		if isChartArchive(release.ChartPath) {
+			release.ChartDigest = fileDigest(release.ChartPath)
+		}
+	case release.ChartRef != "":
+		chartPath, err = install.ChartPathOptions.LocateChart(release.ChartRef, settings)
+		exitOnSDKErr(err)
+		release.ChartDigest = fileDigest(chartPath)
+	default:
+		// Resolve the chart URL first: LocateChart prefers a local directory
+		// named like the chart, and the default output directory is named so
+		chartURL, err := repo.FindChartInRepoURL(release.Repository, release.Chart, release.Version, "", "", "", getter.All(settings))
+		exitOnSDKErr(err)
+		chartPath, err = install.ChartPathOptions.LocateChart(chartURL, settings)
+		exitOnSDKErr(err)
+		release.ChartDigest = fileDigest(chartPath)
+	}
+
+	loadedChart, err := loader.Load(chartPath)
+	exitOnSDKErr(err)
+
+	if release.ChartPath != "" && chartHasDependencies(chartPath) {
+		absoluteFileDependencies(chartPath, release.ChartPath)
+		printDebug("Building chart dependencies\n")
+		manager := &downloader.Manager{
+			Out:              debugWriter{},
+			ChartPath:        chartPath,
+			SkipUpdate:       true,
+			Getters:          getter.All(settings),
+			RegistryClient:   registryClient,
+			RepositoryConfig: settings.RepositoryConfig,
+			RepositoryCache:  settings.RepositoryCache,
+			Debug:            debug,
+		}
+		exitOnSDKErr(manager.Build())
+
+		loadedChart, err = loader.Load(chartPath)
+		exitOnSDKErr(err)
+	}
+
+	release.ResolvedVersion = loadedChart.Metadata.Version
+	return loadedChart
+}
+
+// Split a rendered release manifest back into the files it came from.
+// Every document starts with "---" and a "# Source: <chart>/<path>" comment.
+// Only files from templates/ and crds/ are kept, like in the exec renderer
+func groupManifestsBySource(chartName, manifest string) []renderedFileStruct {
+	var renderedFiles []renderedFileStruct
+	fileIndex := map[string]int{}
+	current := -1
+
+	lines := strings.SplitAfter(manifest, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "---" && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "# Source: "+chartName+"/") {
+			source := strings.TrimSpace(strings.TrimPrefix(lines[i+1], "# Source: "))
+			filePath := strings.TrimPrefix(source, chartName+"/")
+
+			if !strings.HasPrefix(filePath, "templates/") && !strings.HasPrefix(filePath, "crds/") {
+				printDebug("Skipping %v\n", source)
+				current = -1
+				continue
+			}
+
+			index, found := fileIndex[filePath]
+			if !found {
+				index = len(renderedFiles)
+				fileIndex[filePath] = index
+				renderedFiles = append(renderedFiles, renderedFileStruct{Path: filePath})
+			}
+			current = index
+		}
+
+		if current >= 0 {
+			renderedFiles[current].Content = append(renderedFiles[current].Content, line...)
+		}
+	}
+
+	return renderedFiles
+}
+
+// debugWriter passes helm SDK output to the debug log
+type debugWriter struct{}
+
+func (debugWriter) Write(p []byte) (int, error) {
+	printDebug("%s", p)
+	return len(p), nil
+}
+
+func exitOnSDKErr(err error) {
+	if err != nil {
+		fmt.Println("ERROR!", err)
+		exit(1)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testChartYaml = "apiVersion: v2\nname: demo\nversion: 1.0.0\n"
+
+const testChartTemplate = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}
+`
+
+// The user's repositories must not be consulted: their indexes are not in
+// the per-run cache, so helm would fail to find them
+func TestSDKRendererIgnoresUserRepositories(t *testing.T) {
+	dir := t.TempDir()
+	chartArchive := filepath.Join(dir, "demo-1.0.0.tgz")
+	writeTestChartArchiveFiles(t, chartArchive, "demo", map[string]string{
+		"Chart.yaml":               testChartYaml,
+		"templates/configmap.yaml": testChartTemplate,
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/index.yaml":
+			writer.Write([]byte("apiVersion: v1\nentries:\n  demo:\n    - apiVersion: v2\n      name: demo\n      version: 1.0.0\n      urls: [demo-1.0.0.tgz]\n"))
+		case "/demo-1.0.0.tgz":
+			http.ServeFile(writer, request, chartArchive)
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+	defer server.Close()
+
+	repositoryConfig := filepath.Join(dir, "repositories.yaml")
+	writeTestFile(t, repositoryConfig, "apiVersion: v1\nrepositories:\n  - name: bitnami\n    url: https://charts.bitnami.com/bitnami\n")
+	t.Setenv("HELM_REPOSITORY_CONFIG", repositoryConfig)
+	t.Setenv("HELM_REPOSITORY_CACHE", filepath.Join(dir, "cache"))
+
+	dependency := filepath.Join(dir, "dependency")
+	writeTestFile(t, filepath.Join(dependency, "Chart.yaml"), "apiVersion: v2\nname: dependency\nversion: 0.1.0\n")
+	localChart := filepath.Join(dir, "local")
+	writeTestFile(t, filepath.Join(localChart, "Chart.yaml"), testChartYaml+"dependencies:\n  - name: dependency\n    version: 0.1.0\n    repository: file://"+dependency+"\n")
+	writeTestFile(t, filepath.Join(localChart, "templates", "configmap.yaml"), testChartTemplate)
+
+	tests := []struct {
+		name    string
+		release releaseStruct
+	}{
+		{
+			name:    "repository chart",
+			release: releaseStruct{Name: "web", Chart: "demo", Repository: server.URL, Namespace: "ns"},
+		},
+		{
+			name:    "local chart with dependencies",
+			release: releaseStruct{Name: "web", Chart: "demo", ChartPath: localChart, Namespace: "ns"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			release := test.release
+			release.TmpDir = t.TempDir()
+			renderedFiles := (&sdkRenderer{}).Render(&release)
+
+			want := []renderedFileStruct{{Path: "templates/configmap.yaml", Content: []byte("---\n# Source: demo/templates/configmap.yaml\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\n")}}
+			if !reflect.DeepEqual(renderedFiles, want) {
+				t.Errorf("rendered files:\n got %q\nwant %q", renderedFiles, want)
+			}
+			if release.ResolvedVersion != "1.0.0" {
+				t.Errorf("resolved version: got %q, want 1.0.0", release.ResolvedVersion)
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "cache")); !os.IsNotExist(err) {
+		t.Errorf("the user's repository cache was used")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+const unknownKindsError = "error"
+const unknownKindsAuto = "auto"
+const unknownKindsFullname = "fullname"
+
+type CRDStruct struct {
+	Spec struct {
+		Names struct {
+			Kind       string   `yaml:"kind"`
+			ShortNames []string `yaml:"shortNames"`
+		} `yaml:"names"`
+	} `yaml:"spec"`
+}
+
+func validateUnknownKinds(unknownKinds string) {
+	if unknownKinds != "" && unknownKinds != unknownKindsError && unknownKinds != unknownKindsAuto && unknownKinds != unknownKindsFullname {
+		fmt.Printf("ERROR! Unknown value \"%v\" of --unknown-kinds, must be \"%v\", \"%v\" or \"%v\"!\n", unknownKinds, unknownKindsError, unknownKindsAuto, unknownKindsFullname)
+		exit(1)
+	}
+}
+
+// A short name declared by a CustomResourceDefinition of the chart
+type crdShortNameStruct struct {
+	ShortName string
+	CRD       string
+}
+
+// Set a shortcut for every manifest. The config takes precedence, then the
+// first short name of the kind's CRD is used. Other kinds either stop the
+// run, or get a generated shortcut, depending on config.UnknownKinds
+func assignShortcuts(manifests []*outputManifestStruct, config *configStruct) {
+	crdShortNames := findCRDShortNames(manifests)
+	shortcuts := map[string]string{}
+	generated := map[string]string{}
+
+	for _, manifest := range manifests {
+		if shortcut, found := shortcuts[manifest.Kind]; found {
+			manifest.Shortcut = shortcut
+			continue
+		}
+
+		shortcut := config.Shortcuts[manifest.Kind]
+		if shortcut != "" {
+			printDebug("Shortcut \"%v\" for kind %v is taken from %v\n", shortcut, manifest.Kind, config.FilePath)
+		}
+
+		if crd, found := crdShortNames[manifest.Kind]; shortcut == "" && found {
+			if shortcutIsTaken(crd.ShortName, manifest.Kind, config.Shortcuts) || shortcutIsTaken(crd.ShortName, manifest.Kind, shortcuts) {
+				printDebug("Short name \"%v\" of CRD %v is already used by another kind\n", crd.ShortName, crd.CRD)
+			} else {
+				shortcut = crd.ShortName
+				printDebug("Shortcut \"%v\" for kind %v is taken from CRD %v\n", shortcut, manifest.Kind, crd.CRD)
+			}
+		}
+
+		if shortcut == "" {
+			switch config.UnknownKinds {
+			case unknownKindsAuto:
+				shortcut = autoShortcut(manifest.Kind, config.Shortcuts, shortcuts)
+			case unknownKindsFullname:
+				shortcut = strings.ToLower(manifest.Kind)
+			default:
+				fmt.Printf("ERROR! Unknown kind \"%v\"! Add a shortcut for this kind to %v and rerun!\n", manifest.Kind, config.FilePath)
+				printDebug("Caused by this manifest:\n%v", string(manifest.Content))
+				exit(1)
+			}
+
+			printDebug("Shortcut \"%v\" for kind %v is generated\n", shortcut, manifest.Kind)
+			generated[manifest.Kind] = shortcut
+		}
+
+		shortcuts[manifest.Kind] = shortcut
+		manifest.Shortcut = shortcut
+	}
+
+	if saveShortcuts && !dryRun && len(generated) > 0 {
+		saveShortcutsToConfig(config, generated)
+	}
+}
+
+// Get the first short name of every CustomResourceDefinition found in the chart
+func findCRDShortNames(manifests []*outputManifestStruct) map[string]crdShortNameStruct {
+	shortNames := map[string]crdShortNameStruct{}
+
+	for _, manifest := range manifests {
+		if manifest.Kind != "CustomResourceDefinition" {
+			continue
+		}
+
+		var crd CRDStruct
+		if err := manifest.Node.Decode(&crd); err != nil {
+			printDebug("WARNING! Cannot parse CRD %v: %v\n", manifest.Name, err)
+			continue
+		}
+
+		if crd.Spec.Names.Kind != "" && len(crd.Spec.Names.ShortNames) > 0 {
+			shortNames[crd.Spec.Names.Kind] = crdShortNameStruct{ShortName: crd.Spec.Names.ShortNames[0], CRD: manifest.Name}
+		}
+	}
+
+	return shortNames
+}
+
+// Generate a shortcut from capital letters of the kind (ServiceMonitor -> sm).
+// If the shortcut is already taken by another kind, fall back to the lowercase kind
+func autoShortcut(kind string, configShortcuts, shortcuts map[string]string) string {
+	var shortcut string
+	for _, r := range kind {
+		if unicode.IsUpper(r) {
+			shortcut += string(unicode.ToLower(r))
+		}
+	}
+
+	if shortcut == "" || shortcutIsTaken(shortcut, kind, configShortcuts) || shortcutIsTaken(shortcut, kind, shortcuts) {
+		shortcut = strings.ToLower(kind)
+	}
+
+	return shortcut
+}
+
+func shortcutIsTaken(shortcut, kind string, shortcuts map[string]string) bool {
+	for otherKind, otherShortcut := range shortcuts {
+		if otherShortcut == shortcut && otherKind != kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Add generated shortcuts to the "shortcuts" section of the config file,
+// keeping the rest of the file (including comments) as is
+func saveShortcutsToConfig(config *configStruct, generated map[string]string) {
+	configByte, err := os.ReadFile(config.FilePath)
+	checkErr(err)
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(configByte, &document); err != nil || len(document.Content) == 0 {
+		document = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := document.Content[0]
+
+	shortcutsNode := mappingValue(root, "shortcuts")
+	if shortcutsNode == nil || shortcutsNode.Kind != yaml.MappingNode {
+		shortcutsNode = &yaml.Node{Kind: yaml.MappingNode}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "shortcuts"}, shortcutsNode)
+	}
+
+	kinds := make([]string, 0, len(generated))
+	for kind := range generated {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	if config.Shortcuts == nil {
+		config.Shortcuts = map[string]string{}
+	}
+	for _, kind := range kinds {
+		shortcutsNode.Content = append(shortcutsNode.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: kind},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: generated[kind]},
+		)
+		config.Shortcuts[kind] = generated[kind]
+	}
+
+	var output bytes.Buffer
+	encoder := yaml.NewEncoder(&output)
+	encoder.SetIndent(4)
+	err = encoder.Encode(&document)
+	checkErr(err)
+	encoder.Close()
+
+	fmt.Printf("Adding shortcuts for %v to %v\n", strings.Join(kinds, ", "), config.FilePath)
+	err = os.WriteFile(config.FilePath, output.Bytes(), 0644)
+	checkErr(err)
+}
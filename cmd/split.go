@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A single YAML document of a rendered file
+type documentStruct struct {
+	Content []byte     // original text of the document, without the "---" marker
+	Node    *yaml.Node // parsed document
+}
+
+// Split a multi-document YAML file into documents, keeping the original text
+// (comments, quoting, indentation) of every document.
+//
+// Document boundaries are "---" and "..." markers at the start of a line.
+// The YAML spec forbids such lines inside document content, even inside
+// block scalars, so finding them line by line is safe. Every document is
+// then parsed with yaml.Decoder to make sure it really is a single document.
+// Empty documents (nothing but comments, or no content at all) are dropped
+func splitDocuments(content []byte) ([]documentStruct, error) {
+	var documents []documentStruct
+	var current []string
+
+	flush := func() error {
+		text := strings.Join(current, "")
+		current = nil
+
+		document, err := parseDocument(text)
+		if err != nil || document == nil {
+			return err
+		}
+		documents = append(documents, *document)
+		return nil
+	}
+
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		switch {
+		case isDocumentMarker(line, "---"):
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			// "--- |" and the like: the document starts on the marker line
+			if rest := strings.TrimSpace(strings.TrimPrefix(line, "---")); rest != "" && !strings.HasPrefix(rest, "#") {
+				current = append(current, rest+"\n")
+			}
+		case isDocumentMarker(line, "..."):
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		default:
+			current = append(current, line)
+		}
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+func isDocumentMarker(line, marker string) bool {
+	if !strings.HasPrefix(line, marker) {
+		return false
+	}
+	rest := line[len(marker):]
+	return rest == "" || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n' || rest[0] == '\r'
+}
+
+// Parse the text of a single document. Returns nil for empty documents
+func parseDocument(text string) (*documentStruct, error) {
+	decoder := yaml.NewDecoder(strings.NewReader(text))
+
+	var node yaml.Node
+	err := decoder.Decode(&node)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var extra yaml.Node
+	err = decoder.Decode(&extra)
+	if err == nil {
+		return nil, fmt.Errorf("unexpected document boundary in:\n%v", text)
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+
+	if len(node.Content) == 0 || (node.Content[0].Kind == yaml.ScalarNode && node.Content[0].Tag == "!!null") {
+		return nil, nil
+	}
+
+	content := []byte(strings.TrimRight(text, " \t\r\n") + "\n")
+	content = bytes.TrimLeft(content, "\r\n")
+
+	return &documentStruct{Content: content, Node: &node}, nil
+}
+
+// Replace "kind: List" and "kind: <Kind>List" documents by their items,
+// so every item is written to its own file
+func expandLists(documents []documentStruct) ([]documentStruct, error) {
+	var expanded []documentStruct
+
+	for _, document := range documents {
+		items := listItems(document.Node)
+		if items == nil {
+			expanded = append(expanded, document)
+			continue
+		}
+
+		root := document.Node.Content[0]
+		listKind := mappingValue(root, "kind").Value
+		listAPIVersion := mappingValue(root, "apiVersion")
+
+		// Keep comments above the list, e.g. "# Source: ...", for every item
+		headComment := document.Node.HeadComment
+		if headComment == "" && len(root.Content) > 0 {
+			headComment = root.Content[0].HeadComment
+		}
+		printDebug("Expanding %v with %v items\n", listKind, len(items.Content))
+
+		for _, item := range items.Content {
+			if item.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("%v contains an item which is not an object", listKind)
+			}
+
+			// Items of typed lists (e.g. ConfigMapList) may omit their kind
+			if mappingValue(item, "kind") == nil && listKind != "List" {
+				setMappingValue(item, "kind", strings.TrimSuffix(listKind, "List"))
+			}
+			if mappingValue(item, "apiVersion") == nil && listAPIVersion != nil {
+				setMappingValue(item, "apiVersion", listAPIVersion.Value)
+			}
+
+			if len(item.Content) > 0 && item.Content[0].HeadComment == "" {
+				item.Content[0].HeadComment = headComment
+			}
+
+			itemDocument := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{item}}
+			expanded = append(expanded, documentStruct{Content: marshalYaml(itemDocument), Node: itemDocument})
+		}
+	}
+
+	return expanded, nil
+}
+
+// Get items of a List document, nil if the document is not a list
+func listItems(document *yaml.Node) *yaml.Node {
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	kind := mappingValue(root, "kind")
+	if kind == nil || !strings.HasSuffix(kind.Value, "List") {
+		return nil
+	}
+
+	items := mappingValue(root, "items")
+	if items == nil || items.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	return items
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// Set a scalar value, adding the key to the top of the mapping if it is absent
+func setMappingValue(mapping *yaml.Node, key, value string) {
+	if node := mappingValue(mapping, key); node != nil {
+		node.Kind = yaml.ScalarNode
+		node.Tag = "!!str"
+		node.Value = value
+		node.Content = nil
+		return
+	}
+
+	mapping.Content = append([]*yaml.Node{
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: value},
+	}, mapping.Content...)
+}
+
+// Marshal to YAML with the 2-space indentation usual for kubernetes manifests
+func marshalYaml(value interface{}) []byte {
+	var content bytes.Buffer
+	encoder := yaml.NewEncoder(&content)
+	encoder.SetIndent(2)
+	err := encoder.Encode(value)
+	checkErr(err)
+	encoder.Close()
+
+	return content.Bytes()
+}
+
+// Remove a key and its value from the mapping, if it is there
+func removeMappingKey(mapping *yaml.Node, key string) {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitDocuments(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "no leading marker",
+			content: "kind: A\n---\nkind: B\n",
+			want:    []string{"kind: A\n", "kind: B\n"},
+		},
+		{
+			name:    "marker inside a block scalar",
+			content: "---\nkind: ConfigMap\ndata:\n  script: |\n    echo start\n    ---\n    echo end\n",
+			want:    []string{"kind: ConfigMap\ndata:\n  script: |\n    echo start\n    ---\n    echo end\n"},
+		},
+		{
+			name:    "document end markers",
+			content: "kind: A\n...\n---\nkind: B\n...\n",
+			want:    []string{"kind: A\n", "kind: B\n"},
+		},
+		{
+			name:    "leading comments",
+			content: "---\n# Source: demo/templates/a.yaml\nkind: A\n",
+			want:    []string{"# Source: demo/templates/a.yaml\nkind: A\n"},
+		},
+		{
+			name:    "comment-only and empty documents",
+			content: "---\n# Source: demo/templates/empty.yaml\n---\n\n---\nkind: A\n---\n",
+			want:    []string{"kind: A\n"},
+		},
+		{
+			name:    "CRLF line endings",
+			content: "---\r\nkind: A\r\n---\r\nkind: B\r\n",
+			want:    []string{"kind: A\n", "kind: B\n"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			documents, err := splitDocuments([]byte(test.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, document := range documents {
+				got = append(got, string(document.Content))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("documents:\n got %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExpandLists(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "List",
+			content: "# Source: demo/templates/list.yaml\napiVersion: v1\nkind: List\nitems:\n  - apiVersion: v1\n    kind: Service\n    metadata:\n      name: a\n  - kind: Secret\n    metadata:\n      name: b\n",
+			want: []string{
+				"# Source: demo/templates/list.yaml\napiVersion: v1\nkind: Service\nmetadata:\n  name: a\n",
+				"# Source: demo/templates/list.yaml\napiVersion: v1\nkind: Secret\nmetadata:\n  name: b\n",
+			},
+		},
+		{
+			name:    "ConfigMapList",
+			content: "# Source: demo/templates/cms.yaml\napiVersion: v1\nkind: ConfigMapList\nitems:\n  - metadata:\n      name: a\n  - metadata:\n      name: b\n",
+			want: []string{
+				"# Source: demo/templates/cms.yaml\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n",
+				"# Source: demo/templates/cms.yaml\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: b\n",
+			},
+		},
+		{
+			name:    "not a list",
+			content: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n",
+			want:    []string{"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			documents, err := splitDocuments([]byte(test.content))
+			if err == nil {
+				documents, err = expandLists(documents)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, document := range documents {
+				got = append(got, string(document.Content))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("documents:\n got %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExpandListsRejectsScalarItems(t *testing.T) {
+	documents, err := splitDocuments([]byte("apiVersion: v1\nkind: List\nitems:\n  - foo\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := expandLists(documents); err == nil {
+		t.Errorf("expected an error for a list item which is not an object")
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/cli"
+)
+
+var assumeYes bool
+
+// Answers to the upgrade prompts. A single reader is shared by all of them,
+// otherwise the first prompt would buffer the answers piped for the others
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// A resource found in the output directory or rendered by the new chart
+type resourceStruct struct {
+	Kind      string
+	Name      string
+	Namespace string
+	Filename  string
+	Node      *yaml.Node
+}
+
+// Upgrade the selected releases (all of them, if none are selected) to
+// targetVersion, or to the latest stable version. Every upgrade is rendered
+// and split in the temporary directory, shown as a diff with a summary,
+// and written to the output directory only when confirmed
+func upgradeReleases(releases []*releaseStruct, selected []string, targetVersion, lockFile, projectFile string, renderer Renderer, config *configStruct) {
+	lockedVersions := map[string]string{}
+	for _, entry := range readLock(lockFile).Releases {
+		lockedVersions[entry.Name] = entry.Version
+	}
+
+	selectedNames := map[string]bool{}
+	for _, name := range selected {
+		selectedNames[name] = true
+	}
+	for _, name := range selected {
+		if !releaseExists(releases, name) {
+			fmt.Printf("ERROR! There is no release %v!\n", name)
+			exit(1)
+		}
+	}
+
+	// Resolve the new version of every release
+	settings := cli.New()
+	indexes := map[string]*RepoIndexStruct{}
+	currentVersions := map[string]string{}
+	var upgrades []*releaseStruct
+	for _, release := range releases {
+		if len(selected) > 0 && !selectedNames[release.Name] {
+			continue
+		}
+		if release.ChartPath != "" {
+			fmt.Printf("Release %v uses a local chart, skipping\n", release.Name)
+			continue
+		}
+
+		current := release.Version
+		if current == "" {
+			current = lockedVersions[release.Name]
+		}
+
+		target := targetVersion
+		if target == "" {
+			target = upgradeTarget(current, chartVersions(release, settings, indexes))
+		}
+		if target == "" || target == current {
+			fmt.Printf("Release %v is up to date (%v)\n", release.Name, orDash(current))
+			continue
+		}
+
+		currentVersions[release.Name] = current
+		release.Version = target
+		upgrades = append(upgrades, release)
+	}
+	if len(upgrades) == 0 {
+		return
+	}
+
+	createTmpDirs(upgrades)
+	renderedReleases := renderReleases(renderer, upgrades, parallelism)
+
+	var applied []*releaseStruct
+	for i, release := range upgrades {
+		fmt.Printf("Release %v: %v -> %v\n", release.Name, orDash(currentVersions[release.Name]), release.Version)
+
+		manifests := splitAndRename(renderedReleases[i], release, config)
+		if !diffManifests(manifests, release.OutputDir) {
+			applied = append(applied, release)
+			continue
+		}
+		printUpgradeSummary(readOutputResources(release.OutputDir), renderedResources(manifests), replacedFiles(manifests, release.OutputDir))
+
+		if dryRun || !confirmUpgrade(release) {
+			fmt.Printf("Release %v is not upgraded\n", release.Name)
+			continue
+		}
+
+		// Upgrading always replaces the files of the old version
+		overwrite = true
+		writeManifests(manifests)
+		if prune || pruneDryRun {
+			pruneStaleFiles(manifests, release.OutputDir, pruneDryRun)
+		}
+		applied = append(applied, release)
+	}
+
+	if dryRun || len(applied) == 0 {
+		return
+	}
+
+	writeLock(lockFile, applied, false)
+	if projectFile != "" {
+		saveProjectVersions(projectFile, releases, applied)
+	}
+}
+
+// The latest stable version if it is newer than the current one. Releases
+// on a prerelease or on a version above the latest stable are not moved back
+func upgradeTarget(current string, versions []*semver.Version) string {
+	latest := latestVersion(versions, nil)
+	if !isOlderVersion(current, latest) {
+		return ""
+	}
+	return latest
+}
+
+func releaseExists(releases []*releaseStruct, name string) bool {
+	for _, release := range releases {
+		if release.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func confirmUpgrade(release *releaseStruct) bool {
+	if assumeYes {
+		return true
+	}
+
+	fmt.Printf("Apply the upgrade of %v to %v? [y/N] ", release.Name, release.Version)
+	answer, _ := stdinReader.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// Resources in the YAML files of the output directory. Kustomizations
+// and the state file of --prune are not resources
+func readOutputResources(outputDir string) map[string]*resourceStruct {
+	resources := map[string]*resourceStruct{}
+	if fileIsAbsent(outputDir) {
+		return resources
+	}
+
+	err := filepath.WalkDir(outputDir, func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		extension := path.Ext(filename)
+		if (extension != ".yaml" && extension != ".yml") || path.Base(filename) == kustomizationFilename || path.Base(filename) == stateFilename {
+			return nil
+		}
+
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		documents, err := splitDocuments(content)
+		if err == nil && !keepLists {
+			documents, err = expandLists(documents)
+		}
+		if err != nil {
+			printDebug("WARNING! Cannot parse %v: %v\n", filename, err)
+			return nil
+		}
+
+		for _, document := range documents {
+			var obj ManifestStruct
+			if document.Node.Decode(&obj) != nil || obj.Kind == "" {
+				continue
+			}
+			resources[resourceKey(obj.APIVersion, obj.Kind, obj.Metadata.Namespace, obj.Metadata.Name)] = &resourceStruct{
+				Kind:      obj.Kind,
+				Name:      obj.Metadata.Name,
+				Namespace: obj.Metadata.Namespace,
+				Filename:  filepath.Clean(filename),
+				Node:      document.Node,
+			}
+		}
+		return nil
+	})
+	checkErr(err)
+
+	return resources
+}
+
+func renderedResources(manifests []*outputManifestStruct) map[string]*resourceStruct {
+	resources := map[string]*resourceStruct{}
+	for _, manifest := range manifests {
+		// Generated kustomizations have no node
+		if manifest.Node == nil {
+			continue
+		}
+		resources[resourceKey(manifest.APIVersion, manifest.Kind, manifest.Namespace, manifest.Name)] = &resourceStruct{
+			Kind:      manifest.Kind,
+			Name:      manifest.Name,
+			Namespace: manifest.Namespace,
+			Filename:  filepath.Clean(manifest.Filename),
+			Node:      manifest.Node,
+		}
+	}
+	return resources
+}
+
+func resourceKey(apiVersion, kind, namespace, name string) string {
+	return strings.Join([]string{apiGroup(apiVersion), kind, namespace, name}, "/")
+}
+
+func (r *resourceStruct) String() string {
+	if r.Namespace != "" {
+		return fmt.Sprintf("%v/%v (namespace: %v)", r.Kind, r.Name, r.Namespace)
+	}
+	return fmt.Sprintf("%v/%v", r.Kind, r.Name)
+}
+
+// Files of the output directory the applied upgrade writes or prunes.
+// Files of removed resources not in this list stay on disk
+func replacedFiles(manifests []*outputManifestStruct, outputDir string) map[string]bool {
+	files := map[string]bool{}
+	for _, manifest := range manifests {
+		files[filepath.Clean(manifest.Filename)] = true
+	}
+	if prune && !pruneDryRun {
+		for _, file := range staleFiles(manifests, outputDir) {
+			files[filepath.Join(outputDir, file)] = true
+		}
+	}
+	return files
+}
+
+// Print what the upgrade changes in terms of resources rather than lines:
+// added and removed resources, changed images and CRD schemas
+func printUpgradeSummary(oldResources, newResources map[string]*resourceStruct, replaced map[string]bool) {
+	var lines []string
+
+	for _, key := range sortedKeys(newResources) {
+		if _, found := oldResources[key]; !found {
+			lines = append(lines, "  Added:   "+newResources[key].String())
+		}
+	}
+	for _, key := range sortedKeys(oldResources) {
+		if _, found := newResources[key]; !found {
+			lines = append(lines, "  Removed: "+oldResources[key].String()+removedFileNote(oldResources[key], replaced))
+		}
+	}
+
+	var imageLines, crdLines []string
+	for _, key := range sortedKeys(newResources) {
+		oldResource, found := oldResources[key]
+		if !found {
+			continue
+		}
+		newResource := newResources[key]
+
+		removedImages, addedImages := changedImages(resourceImages(oldResource.Node), resourceImages(newResource.Node))
+		if len(removedImages) > 0 || len(addedImages) > 0 {
+			imageLines = append(imageLines, fmt.Sprintf("  Image:   %v: %v -> %v", newResource, orDash(strings.Join(removedImages, ", ")), orDash(strings.Join(addedImages, ", "))))
+		}
+
+		if newResource.Kind == "CustomResourceDefinition" {
+			if changes := crdSchemaChanges(oldResource.Node, newResource.Node); len(changes) > 0 {
+				crdLines = append(crdLines, fmt.Sprintf("  CRD:     %v: %v", newResource.Name, strings.Join(changes, ", ")))
+			}
+		}
+	}
+	lines = append(append(lines, imageLines...), crdLines...)
+
+	fmt.Println("Summary:")
+	if len(lines) == 0 {
+		fmt.Println("  No resources added or removed, no images or CRD schemas changed")
+		return
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
+
+func removedFileNote(resource *resourceStruct, replaced map[string]bool) string {
+	switch {
+	case replaced[resource.Filename]:
+		return ""
+	case prune:
+		return fmt.Sprintf(" (stays in %v)", resource.Filename)
+	default:
+		return fmt.Sprintf(" (stays in %v, use --prune)", resource.Filename)
+	}
+}
+
+// All values of "image" keys anywhere in the resource, sorted and unique
+func resourceImages(node *yaml.Node) []string {
+	images := map[string]bool{}
+
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if node.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == "image" && node.Content[i+1].Kind == yaml.ScalarNode {
+					images[node.Content[i+1].Value] = true
+				}
+			}
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	walk(node)
+
+	var sorted []string
+	for image := range images {
+		sorted = append(sorted, image)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// Images only in the old list and images only in the new one
+func changedImages(oldImages, newImages []string) ([]string, []string) {
+	oldSet, newSet := map[string]bool{}, map[string]bool{}
+	for _, image := range oldImages {
+		oldSet[image] = true
+	}
+	for _, image := range newImages {
+		newSet[image] = true
+	}
+
+	var removed, added []string
+	for _, image := range oldImages {
+		if !newSet[image] {
+			removed = append(removed, image)
+		}
+	}
+	for _, image := range newImages {
+		if !oldSet[image] {
+			added = append(added, image)
+		}
+	}
+	return removed, added
+}
+
+// Compare the versions of two CRDs and their OpenAPI schemas
+func crdSchemaChanges(oldNode, newNode *yaml.Node) []string {
+	oldSchemas, newSchemas := crdSchemas(oldNode), crdSchemas(newNode)
+	var changes []string
+
+	for _, name := range sortedKeys(newSchemas) {
+		oldSchema, found := oldSchemas[name]
+		switch {
+		case !found:
+			changes = append(changes, "version "+name+" added")
+		case !bytes.Equal(oldSchema, newSchemas[name]):
+			changes = append(changes, "schema of "+name+" changed")
+		}
+	}
+	for _, name := range sortedKeys(oldSchemas) {
+		if _, found := newSchemas[name]; !found {
+			changes = append(changes, "version "+name+" removed")
+		}
+	}
+
+	return changes
+}
+
+func crdSchemas(node *yaml.Node) map[string][]byte {
+	var crd struct {
+		Spec struct {
+			Versions []struct {
+				Name   string      `yaml:"name"`
+				Schema interface{} `yaml:"schema"`
+			} `yaml:"versions"`
+		} `yaml:"spec"`
+	}
+	if err := node.Decode(&crd); err != nil {
+		printDebug("WARNING! Cannot parse CRD: %v\n", err)
+	}
+
+	schemas := map[string][]byte{}
+	for _, crdVersion := range crd.Spec.Versions {
+		schema, err := yaml.Marshal(crdVersion.Schema)
+		checkErr(err)
+		schemas[crdVersion.Name] = schema
+	}
+	return schemas
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Set the new "version" of upgraded releases in the project file,
+// keeping the rest of the file (including comments) as is
+func saveProjectVersions(projectFile string, releases, upgraded []*releaseStruct) {
+	projectByte, err := os.ReadFile(projectFile)
+	checkErr(err)
+
+	var document yaml.Node
+	err = yaml.Unmarshal(projectByte, &document)
+	checkErr(err)
+
+	releasesNode := mappingValue(document.Content[0], "releases")
+	for i, release := range releases {
+		if !releaseExists(upgraded, release.Name) || i >= len(releasesNode.Content) {
+			continue
+		}
+
+		releaseNode := releasesNode.Content[i]
+		if versionNode := mappingValue(releaseNode, "version"); versionNode != nil {
+			versionNode.Value = release.Version
+			versionNode.Tag = "!!str"
+		} else {
+			releaseNode.Content = append(releaseNode.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"},
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: release.Version},
+			)
+		}
+	}
+
+	var output bytes.Buffer
+	encoder := yaml.NewEncoder(&output)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&document)
+	checkErr(err)
+	encoder.Close()
+
+	fmt.Printf("Updating versions in %v\n", projectFile)
+	err = os.WriteFile(projectFile, output.Bytes(), 0644)
+	checkErr(err)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+func TestConfirmUpgradeReadsOneAnswerPerPrompt(t *testing.T) {
+	defer func(reader *bufio.Reader) { stdinReader = reader }(stdinReader)
+	stdinReader = bufio.NewReader(strings.NewReader("y\nno\nYES\n"))
+
+	var got []bool
+	for _, name := range []string{"a", "b", "c", "d"} {
+		got = append(got, confirmUpgrade(&releaseStruct{Name: name, Version: "2.0.0"}))
+	}
+
+	want := []bool{true, false, true, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("answers: got %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestUpgradeTarget(t *testing.T) {
+	var versions []*semver.Version
+	for _, text := range []string{"1.2.0", "1.10.0", "2.0.0", "2.1.0-rc.1"} {
+		versions = append(versions, semver.MustParse(text))
+	}
+
+	tests := []struct {
+		current string
+		target  string
+	}{
+		{current: "1.2.0", target: "2.0.0"},
+		{current: "2.0.0", target: ""},
+		// Never moved back from a prerelease or a newer version
+		{current: "2.1.0-rc.1", target: ""},
+		{current: "3.0.0", target: ""},
+		{current: "", target: ""},
+	}
+	for _, test := range tests {
+		if got := upgradeTarget(test.current, versions); got != test.target {
+			t.Errorf("upgrade target of %q: got %q, want %q", test.current, got, test.target)
+		}
+	}
+}
+
+func TestRemovedFileNote(t *testing.T) {
+	defer func(oldPrune, oldPruneDryRun bool) { prune, pruneDryRun = oldPrune, oldPruneDryRun }(prune, pruneDryRun)
+
+	outputDir := t.TempDir()
+	writeTestFile(t, filepath.Join(outputDir, stateFilename), "files:\n  - deploy-d.yaml\n  - svc-s.yaml\n")
+	writeTestFile(t, filepath.Join(outputDir, "deploy-d.yaml"), "kind: Deployment\n")
+	writeTestFile(t, filepath.Join(outputDir, "svc-s.yaml"), "kind: Service\n")
+	writeTestFile(t, filepath.Join(outputDir, "job-j.yaml"), "kind: Job\n")
+	manifests := []*outputManifestStruct{{Filename: filepath.Join(outputDir, "svc-s.yaml")}}
+
+	stale := &resourceStruct{Kind: "Deployment", Name: "d", Filename: filepath.Join(outputDir, "deploy-d.yaml")}
+	rewritten := &resourceStruct{Kind: "Service", Name: "other", Filename: filepath.Join(outputDir, "svc-s.yaml")}
+	notInState := &resourceStruct{Kind: "Job", Name: "j", Filename: filepath.Join(outputDir, "job-j.yaml")}
+
+	tests := []struct {
+		name        string
+		prune       bool
+		pruneDryRun bool
+		resource    *resourceStruct
+		want        string
+	}{
+		{name: "without --prune", resource: stale, want: " (stays in " + stale.Filename + ", use --prune)"},
+		{name: "with --prune", prune: true, resource: stale, want: ""},
+		{name: "with --prune-dry-run", prune: true, pruneDryRun: true, resource: stale, want: " (stays in " + stale.Filename + ")"},
+		{name: "file is rewritten", resource: rewritten, want: ""},
+		{name: "file not generated with --prune", prune: true, resource: notInState, want: " (stays in " + notInState.Filename + ")"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prune, pruneDryRun = test.prune, test.pruneDryRun
+			if got := removedFileNote(test.resource, replacedFiles(manifests, outputDir)); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
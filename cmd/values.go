@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/cli/values"
+)
+
+const setFlag = "set"
+const setStringFlag = "set-string"
+const setFileFlag = "set-file"
+const setJSONFlag = "set-json"
+
+// A --set, --set-string, --set-file or --set-json value, kept in
+// the order it was given so it is passed to helm in the same order
+type setValueStruct struct {
+	Flag  string
+	Value string
+}
+
+func (s setValueStruct) String() string {
+	return "--" + s.Flag + " " + s.Value
+}
+
+func validateSetValue(flagName, value string) error {
+	if flagName != setJSONFlag && !strings.Contains(value, "=") {
+		return fmt.Errorf("value \"%v\" must look like key=value", value)
+	}
+	return nil
+}
+
+// Values of a release in the form the helm SDK takes them. Like the helm
+// binary, the SDK applies values files first, then --set-json, --set,
+// --set-string and --set-file values, each kind in the given order
+func releaseValuesOptions(release *releaseStruct) *values.Options {
+	options := &values.Options{ValueFiles: release.ValuesFiles}
+
+	for _, setValue := range release.SetValues {
+		switch setValue.Flag {
+		case setFlag:
+			options.Values = append(options.Values, setValue.Value)
+		case setStringFlag:
+			options.StringValues = append(options.StringValues, setValue.Value)
+		case setFileFlag:
+			options.FileValues = append(options.FileValues, setValue.Value)
+		case setJSONFlag:
+			options.JSONValues = append(options.JSONValues, setValue.Value)
+		}
+	}
+
+	return options
+}